@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/controller"
+)
+
+func main() {
+	cfg := config.NewMigrateConfig()
+	cfg.Parse()
+
+	if cfg.From == "" || cfg.To == "" {
+		log.Fatal("both -from and -to must be set")
+	}
+
+	src, err := controller.OpenBackupStorage(cfg.From, &cfg.Storage)
+	if err != nil {
+		log.Fatalf("Error %s opening source", err)
+	}
+	defer src.Close()
+
+	dst, err := controller.OpenBackupStorage(cfg.To, &cfg.Storage)
+	if err != nil {
+		// log.Fatalf exits without running deferred calls.
+		src.Close()
+		log.Fatalf("Error %s opening destination", err)
+	}
+	defer dst.Close()
+
+	result, err := controller.Migrate(context.Background(), src, dst, controller.MigrateOptions{
+		Policy:    controller.MergePolicy(cfg.Policy),
+		DryRun:    cfg.DryRun,
+		BatchSize: cfg.BatchSize,
+		Progress:  os.Stdout,
+	})
+	for _, name := range result.Mismatched {
+		fmt.Fprintf(os.Stderr, "mismatch: %s\n", name)
+	}
+	if err != nil {
+		src.Close()
+		dst.Close()
+		log.Fatalf("Error %s migrating metrics", err)
+	}
+
+	if cfg.DryRun {
+		fmt.Println("dry run, nothing written")
+	}
+}
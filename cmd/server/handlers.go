@@ -2,13 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/h3ll0kitt1/observability/internal/auth"
+	"github.com/h3ll0kitt1/observability/internal/controller"
 	"github.com/h3ll0kitt1/observability/internal/hash"
 	"github.com/h3ll0kitt1/observability/internal/models"
 )
@@ -44,24 +49,111 @@ func (app *application) getList(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) ping(w http.ResponseWriter, r *http.Request) {
 
-	if app.config.Database == "" {
+	if err := app.storageManager.Ping(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+type readiness struct {
+	Ready   bool                   `json:"ready"`
+	Storage storageReadiness       `json:"storage"`
+	Backup  backupReadiness        `json:"backup"`
+	Restore restoreReadiness       `json:"restore"`
+	Cache   *controller.CacheStats `json:"cache,omitempty"`
+	Pool    *controller.PoolStats  `json:"pool,omitempty"`
+}
+
+type storageReadiness struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type backupReadiness struct {
+	LastFlush           *time.Time `json:"last_flush,omitempty"`
+	LastFlushError      string     `json:"last_flush_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	AgeSeconds          float64    `json:"age_seconds"`
+	Stale               bool       `json:"stale"`
+	Degraded            bool       `json:"degraded"`
+}
+
+type restoreReadiness struct {
+	Status controller.RestoreStatus `json:"status"`
+	Error  string                   `json:"error,omitempty"`
+}
+
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	status := app.storageManager.Status()
+
+	var ready readiness
+
+	ready.Storage.OK = true
 	if err := app.storageManager.Ping(); err != nil {
+		ready.Storage.OK = false
+		ready.Storage.Error = err.Error()
+	}
+
+	if !status.LastFlush.IsZero() {
+		ready.Backup.LastFlush = &status.LastFlush
+	}
+	if status.LastFlushError != nil {
+		ready.Backup.LastFlushError = status.LastFlushError.Error()
+	}
+	ready.Backup.ConsecutiveFailures = status.ConsecutiveFailures
+	ready.Backup.AgeSeconds = status.BackupAge(now).Seconds()
+	ready.Backup.Stale = status.Stale(now, app.config.StaleThreshold)
+	ready.Backup.Degraded = status.Degraded
+
+	ready.Restore.Status = status.Restore
+	if status.RestoreError != nil {
+		ready.Restore.Error = status.RestoreError.Error()
+	}
+
+	if stats, ok := app.storageManager.CacheStats(); ok {
+		ready.Cache = &stats
+	}
+	if stats, ok := app.storageManager.PoolStats(); ok {
+		ready.Pool = &stats
+	}
+
+	ready.Ready = ready.Storage.OK && !ready.Backup.Stale && status.Restore != controller.RestoreFailed
+
+	jsonData, err := json.Marshal(ready)
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	code := http.StatusOK
+	if !ready.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(jsonData)
 }
 
+var (
+	errBatchTooLarge = errors.New("too many metrics in batch")
+	errNotList       = errors.New("metrics batch is not a list")
+)
+
 func (app *application) getValue(w http.ResponseWriter, r *http.Request) {
 	var metric models.Metrics
 	err := json.NewDecoder(r.Body).Decode(&metric)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(decodeErrorStatus(err))
 		return
 	}
 
@@ -150,9 +242,12 @@ func (app *application) getGauge(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) updateList(w http.ResponseWriter, r *http.Request) {
-	var list []models.Metrics
-	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	list, err := decodeList(r.Body, app.config.MaxBatchSize)
+	if err != nil {
+		app.logger.Errorw("error",
+			"decode list", err,
+		)
+		w.WriteHeader(decodeErrorStatus(err))
 		return
 	}
 
@@ -168,7 +263,7 @@ func (app *application) updateList(w http.ResponseWriter, r *http.Request) {
 			"update list", err,
 		)
 
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(updateErrorStatus(err))
 		return
 	}
 
@@ -179,7 +274,7 @@ func (app *application) updateValue(w http.ResponseWriter, r *http.Request) {
 	var metric models.Metrics
 	err := json.NewDecoder(r.Body).Decode(&metric)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(decodeErrorStatus(err))
 		return
 	}
 
@@ -190,7 +285,7 @@ func (app *application) updateValue(w http.ResponseWriter, r *http.Request) {
 			"update value", err,
 		)
 
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(updateErrorStatus(err))
 		return
 	}
 
@@ -234,7 +329,7 @@ func (app *application) updateCounter(w http.ResponseWriter, r *http.Request) {
 			"update counter", err,
 		)
 
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(updateErrorStatus(err))
 		return
 	}
 
@@ -261,11 +356,78 @@ func (app *application) updateGauge(w http.ResponseWriter, r *http.Request) {
 			"update gauge", err,
 		)
 
+		w.WriteHeader(updateErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) reloadTokens(w http.ResponseWriter, r *http.Request) {
+
+	if app.tokens == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := app.tokens.Reload(); err != nil {
+		app.logger.Errorw("error",
+			"reload tokens", err,
+		)
+		w.WriteHeader(reloadErrorStatus(err))
+		return
+	}
+
+	app.logger.Infow("tokens reloaded",
+		"file", app.config.TokensFile,
+	)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := app.storageManager.Snapshots()
+	if errors.Is(err, controller.ErrSnapshotsUnsupported) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.logger.Errorw("error",
+			"list snapshots", err,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.Marshal(snapshots)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+func (app *application) cardinality(w http.ResponseWriter, r *http.Request) {
+	report, err := app.storageManager.Cardinality(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		app.logger.Errorw("error",
+			"cardinality", err,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsonData, err := json.Marshal(report)
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
 }
 
 func errorUnknown(w http.ResponseWriter, r *http.Request) {
@@ -280,6 +442,73 @@ func errorNoName(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 }
 
+func decodeList(body io.Reader, maxSize int) ([]models.Metrics, error) {
+	decoder := json.NewDecoder(body)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('[') {
+		return nil, errNotList
+	}
+
+	list := make([]models.Metrics, 0)
+	for decoder.More() {
+		if maxSize > 0 && len(list) >= maxSize {
+			return nil, errBatchTooLarge
+		}
+
+		var metric models.Metrics
+		if err := decoder.Decode(&metric); err != nil {
+			return nil, err
+		}
+		list = append(list, metric)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func updateErrorStatus(err error) int {
+	if errors.Is(err, controller.ErrDegraded) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, controller.ErrCardinalityLimit) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func decodeErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, errBatchTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, errNotList) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func reloadErrorStatus(err error) int {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, auth.ErrEmptyID),
+		errors.Is(err, auth.ErrDuplicateID),
+		errors.Is(err, auth.ErrEmptyToken),
+		errors.Is(err, auth.ErrUnknownScope),
+		errors.As(err, &syntaxErr),
+		errors.As(err, &typeErr):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func validateStringIsInt64(s string) (int64, bool) {
 	value, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-resty/resty/v2"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/h3ll0kitt1/observability/internal/auth"
 	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/controller"
 	"github.com/h3ll0kitt1/observability/internal/logger"
 	"github.com/h3ll0kitt1/observability/internal/mocks"
 	"github.com/h3ll0kitt1/observability/internal/models"
@@ -319,12 +326,14 @@ func TestHandler_updateList(t *testing.T) {
 	sm := mocks.NewMockStorageManager(ctrl)
 
 	r := chi.NewRouter()
+	c := config.NewServerConfig()
 	l := logger.NewLogger()
 
 	app := &application{
 		storageManager: sm,
 		router:         r,
 		logger:         l,
+		config:         c,
 	}
 	app.setRouters()
 
@@ -332,15 +341,13 @@ func TestHandler_updateList(t *testing.T) {
 	srv := httptest.NewServer(handler)
 	defer srv.Close()
 
-	sm.EXPECT().
-		UpdateList(gomock.Any(), gomock.Any()).
-		Return(nil)
-
 	testCases := []struct {
 		name         string
 		path         string
 		method       string
 		body         string
+		err          error
+		notDecoded   bool
 		expectedCode int
 		expectedBody string
 	}{
@@ -352,10 +359,43 @@ func TestHandler_updateList(t *testing.T) {
 			expectedCode: http.StatusOK,
 			expectedBody: "",
 		},
+		{
+			name:         "cardinality_limit",
+			path:         "/updates/",
+			method:       http.MethodPost,
+			body:         `[{"id":"newCounter","type":"counter","delta":1}]`,
+			err:          fmt.Errorf("%w: 10 series stored", controller.ErrCardinalityLimit),
+			expectedCode: http.StatusUnprocessableEntity,
+			expectedBody: "",
+		},
+		{
+			name:         "null_body",
+			path:         "/updates/",
+			method:       http.MethodPost,
+			body:         `null`,
+			notDecoded:   true,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "",
+		},
+		{
+			name:         "object_body",
+			path:         "/updates/",
+			method:       http.MethodPost,
+			body:         `{"id":"testCounter","type":"counter","delta":1}`,
+			notDecoded:   true,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "",
+		},
 	}
 
 	for _, tc := range testCases {
-		t.Run(tc.method, func(t *testing.T) {
+		t.Run(tc.name, func(t *testing.T) {
+			if !tc.notDecoded {
+				sm.EXPECT().
+					UpdateList(gomock.Any(), gomock.Any()).
+					Return(tc.err)
+			}
+
 			req := resty.New().R()
 			req.Method = tc.method
 			req.URL = srv.URL
@@ -399,14 +439,11 @@ func TestHandler_updateValue(t *testing.T) {
 	srv := httptest.NewServer(handler)
 	defer srv.Close()
 
-	sm.EXPECT().
-		Update(gomock.Any(), gomock.Any()).
-		Return(nil)
-
 	testCases := []struct {
 		name         string
 		method       string
 		body         string
+		updateErr    error
 		expectedCode int
 		expectedBody string
 	}{
@@ -417,10 +454,28 @@ func TestHandler_updateValue(t *testing.T) {
 			expectedCode: http.StatusOK,
 			expectedBody: "",
 		},
+		{
+			name:         "degraded",
+			method:       http.MethodPost,
+			body:         `{"id":"testCounter","type":"counter","delta":1}`,
+			updateErr:    controller.ErrDegraded,
+			expectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:         "storage_error",
+			method:       http.MethodPost,
+			body:         `{"id":"testCounter","type":"counter","delta":1}`,
+			updateErr:    errors.New("storage error"),
+			expectedCode: http.StatusInternalServerError,
+		},
 	}
 
 	for _, tc := range testCases {
-		t.Run(tc.method, func(t *testing.T) {
+		t.Run(tc.name, func(t *testing.T) {
+			sm.EXPECT().
+				Update(gomock.Any(), gomock.Any()).
+				Return(tc.updateErr)
+
 			req := resty.New().R()
 			req.Method = tc.method
 			req.URL = srv.URL
@@ -440,3 +495,380 @@ func TestHandler_updateValue(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_readyz(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	c.StaleThreshold = time.Minute
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+	}
+	app.setRouters()
+
+	handler := http.HandlerFunc(app.readyz)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	now := time.Now()
+
+	testCases := []struct {
+		name         string
+		status       controller.Status
+		pingErr      error
+		cache        *controller.CacheStats
+		pool         *controller.PoolStats
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name: "fresh backup",
+			status: controller.Status{
+				Started:       now.Add(-time.Hour),
+				StoreInterval: 10 * time.Second,
+				LastFlush:     now.Add(-10 * time.Second),
+				Restore:       controller.RestoreOK,
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `"ready":true`,
+		},
+		{
+			name: "stale backup",
+			status: controller.Status{
+				Started:        now.Add(-time.Hour),
+				StoreInterval:  10 * time.Second,
+				LastFlush:      now.Add(-10 * time.Minute),
+				LastFlushError: errors.New("no space left on device"),
+				Restore:        controller.RestoreOK,
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `"last_flush_error":"no space left on device"`,
+		},
+		{
+			name: "storage unavailable",
+			status: controller.Status{
+				Started: now,
+				Restore: controller.RestoreSkipped,
+			},
+			pingErr:      errors.New("connection refused"),
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `"storage":{"ok":false,"error":"connection refused"}`,
+		},
+		{
+			name: "database cache",
+			status: controller.Status{
+				Started: now,
+				Restore: controller.RestoreSkipped,
+			},
+			cache:        &controller.CacheStats{Hits: 3, Misses: 1},
+			expectedCode: http.StatusOK,
+			expectedBody: `"cache":{"hits":3,"misses":1,"pending":0}`,
+		},
+		{
+			name: "database pool",
+			status: controller.Status{
+				Started: now,
+				Restore: controller.RestoreSkipped,
+			},
+			pool:         &controller.PoolStats{AcquiredConns: 2, IdleConns: 1, TotalConns: 3, MaxConns: 4, AcquireCount: 10, AcquireDurationSeconds: 0.5},
+			expectedCode: http.StatusOK,
+			expectedBody: `"pool":{"acquired_conns":2,"idle_conns":1,"total_conns":3,"max_conns":4,"acquire_count":10,"empty_acquire_count":0,"canceled_acquire_count":0,"acquire_duration_seconds":0.5}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sm.EXPECT().Status().Return(tc.status)
+			sm.EXPECT().Ping().Return(tc.pingErr)
+			if tc.cache != nil {
+				sm.EXPECT().CacheStats().Return(*tc.cache, true)
+			} else {
+				sm.EXPECT().CacheStats().Return(controller.CacheStats{}, false)
+			}
+			if tc.pool != nil {
+				sm.EXPECT().PoolStats().Return(*tc.pool, true)
+			} else {
+				sm.EXPECT().PoolStats().Return(controller.PoolStats{}, false)
+			}
+
+			resp, err := resty.New().R().Get(srv.URL)
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+			assert.Contains(t, string(resp.Body()), tc.expectedBody)
+		})
+	}
+}
+
+func TestHandler_ping(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+	}
+	app.setRouters()
+
+	handler := http.HandlerFunc(app.ping)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	testCases := []struct {
+		name         string
+		pingErr      error
+		expectedCode int
+	}{
+		{
+			name:         "in-memory storage",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "database unavailable",
+			pingErr:      errors.New("connection refused"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sm.EXPECT().Ping().Return(tc.pingErr)
+
+			resp, err := resty.New().R().Get(srv.URL)
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+		})
+	}
+}
+
+func TestHandler_reloadTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	filename := filepath.Join(t.TempDir(), "tokens.json")
+	if err := os.WriteFile(filename, []byte(`[{"id":"ops","token":"ops-secret","scopes":["admin"]}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := auth.NewTokens(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	c.TokensFile = filename
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+		tokens:         tokens,
+	}
+	app.setRouters()
+
+	handler := http.HandlerFunc(app.reloadTokens)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	testCases := []struct {
+		name         string
+		data         string
+		expectedCode int
+	}{
+		{
+			name:         "valid tokens",
+			data:         `[{"id":"ops","token":"ops-secret","scopes":["admin"]},{"id":"agent","token":"agent-secret","scopes":["write"]}]`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "empty id",
+			data:         `[{"id":"","token":"agent-secret","scopes":["write"]}]`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "duplicate id",
+			data:         `[{"id":"ops","token":"ops-secret","scopes":["admin"]},{"id":"ops","token":"agent-secret","scopes":["write"]}]`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "malformed json",
+			data:         `[{"id":"ops"`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.WriteFile(filename, []byte(tc.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := resty.New().R().Post(srv.URL)
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if err := os.Remove(filename); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := resty.New().R().Post(srv.URL)
+		assert.NoError(t, err, "error making HTTP request")
+
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode(), "Response code didn't match expected")
+	})
+}
+
+func TestHandler_listSnapshots(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+	}
+	app.setRouters()
+
+	handler := http.HandlerFunc(app.listSnapshots)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	created := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name         string
+		snapshots    []models.Snapshot
+		err          error
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name: "snapshots listed",
+			snapshots: []models.Snapshot{
+				{Name: "metrics-db.json.snapshot-20261019T120000.000000000Z", Created: created, Size: 42},
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"name":"metrics-db.json.snapshot-20261019T120000.000000000Z","created":"2026-10-19T12:00:00Z","size":42}]`,
+		},
+		{
+			name:         "backup without snapshots",
+			err:          controller.ErrSnapshotsUnsupported,
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sm.EXPECT().Snapshots().Return(tc.snapshots, tc.err)
+
+			resp, err := resty.New().R().Get(srv.URL)
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+			if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, string(resp.Body()))
+			}
+		})
+	}
+}
+
+func TestHandler_cardinality(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+	}
+	app.setRouters()
+
+	handler := http.HandlerFunc(app.cardinality)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	testCases := []struct {
+		name         string
+		prefix       string
+		report       controller.Cardinality
+		err          error
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:   "series by prefix",
+			prefix: "http_",
+			report: controller.Cardinality{
+				Series:   3,
+				Prefix:   "http_",
+				Matched:  2,
+				Prefixes: map[string]int{"http_requests": 2},
+				Agents:   map[string]int{"ip:127.0.0.1": 3},
+				Dropped:  1,
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"series":3,"max_series":0,"max_series_per_agent":0,"prefix":"http_","matched":2,
+				"prefixes":{"http_requests":2},"agents":{"ip:127.0.0.1":3},"dropped":1,"rejected":0}`,
+		},
+		{
+			name:         "storage error",
+			err:          errors.New("connection refused"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sm.EXPECT().Cardinality(gomock.Any(), tc.prefix).Return(tc.report, tc.err)
+
+			resp, err := resty.New().R().SetQueryParam("prefix", tc.prefix).Get(srv.URL)
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+			if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, string(resp.Body()))
+			}
+		})
+	}
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
+	"github.com/h3ll0kitt1/observability/internal/auth"
 	"github.com/h3ll0kitt1/observability/internal/config"
 	"github.com/h3ll0kitt1/observability/internal/controller"
 	"github.com/h3ll0kitt1/observability/internal/logger"
-	"github.com/h3ll0kitt1/observability/internal/storage/sql"
+	"github.com/h3ll0kitt1/observability/internal/ratelimit"
 )
 
 type application struct {
@@ -18,6 +27,9 @@ type application struct {
 	storageManager controller.StorageManager
 	router         *chi.Mux
 	logger         *zap.SugaredLogger
+	tokens         *auth.Tokens
+	updateLimiter  *ratelimit.Limiter
+	updatesLimiter *ratelimit.Limiter
 }
 
 func main() {
@@ -25,20 +37,22 @@ func main() {
 	cfg := config.NewServerConfig()
 	cfg.Parse()
 
-	sm := controller.NewStorageManager(cfg)
-	sm.SetRetryCount(3)
-	sm.SetRetryStartWaitTime(1)
-	sm.SetRetryIncreaseWaitTime(2)
-
-	if cfg.Database != "" {
-		db, err := sql.NewStorage(cfg)
-		if err != nil {
-			log.Fatalf("Error %s open database", err)
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(cfg, args[1:], os.Stdout); err != nil {
+			log.Fatalf("Error %s migrating database", err)
 		}
-		sm.Set(db)
+		return
+	}
+
+	sm, err := controller.NewStorageManager(cfg)
+	if err != nil {
+		log.Fatalf("Error %s creating storage", err)
 	}
+	sm.SetRetryCount(3)
+	sm.SetRetryStartWaitTime(time.Second)
+	sm.SetRetryIncreaseWaitTime(2 * time.Second)
 
-	if cfg.Restore {
+	if cfg.Restore || cfg.RestoreFrom != "" {
 		if err := sm.Load(); err != nil {
 			log.Fatalf("Error %s loading from disk", err)
 		}
@@ -48,22 +62,107 @@ func main() {
 	l := logger.NewLogger()
 	defer l.Sync()
 
+	sm.SetLogger(l)
+
 	app := &application{
 		config:         cfg,
 		storageManager: sm,
 		router:         r,
 		logger:         l,
 	}
+
+	if cfg.TokensFile != "" {
+		tokens, err := auth.NewTokens(cfg.TokensFile)
+		if err != nil {
+			log.Fatalf("Error %s loading tokens", err)
+		}
+		app.tokens = tokens
+		go app.reloadTokensOnSignal()
+	}
+
+	if cfg.UpdateRate > 0 {
+		app.updateLimiter = ratelimit.NewLimiter(cfg.UpdateRate, cfg.UpdateBurst)
+	}
+
+	if cfg.UpdatesRate > 0 {
+		app.updatesLimiter = ratelimit.NewLimiter(cfg.UpdatesRate, cfg.UpdatesBurst)
+	}
 	app.setRouters()
 
-	go app.storageManager.Run()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	runCtx, stopRun := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- app.storageManager.Run(runCtx)
+	}()
 
 	srv := &http.Server{
 		Addr:    cfg.Addr,
 		Handler: app.router,
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatalf("Error %s launching server", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		app.logger.Infow("shutting down",
+			"reason", ctx.Err(),
+		)
+	case err = <-serveErr:
+		app.logger.Errorw("error",
+			"launching server", err,
+		)
+	}
+
+	err = errors.Join(err, app.shutdown(srv, stopRun, runErr))
+	if err != nil {
+		app.logger.Errorw("error",
+			"shutdown", err,
+		)
+		l.Sync()
+		os.Exit(1)
+	}
+	app.logger.Infow("server stopped")
+}
+
+func (app *application) shutdown(srv *http.Server, stopRun context.CancelFunc, runErr <-chan error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := srv.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("drain requests: %w", err))
+	}
+
+	stopRun()
+	if err := <-runErr; err != nil {
+		errs = append(errs, fmt.Errorf("final flush: %w", err))
+	}
+
+	if err := app.storageManager.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close storage: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (app *application) reloadTokensOnSignal() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	for range sighup {
+		if err := app.tokens.Reload(); err != nil {
+			app.logger.Errorw("error",
+				"reload tokens", err,
+			)
+			continue
+		}
+		app.logger.Infow("tokens reloaded",
+			"file", app.config.TokensFile,
+		)
 	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/logger"
+	"github.com/h3ll0kitt1/observability/internal/mocks"
+)
+
+func TestApplication_shutdown(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		flushErr  error
+		closeErr  error
+		wantError bool
+	}{
+		{
+			name:      "clean shutdown",
+			wantError: false,
+		},
+		{
+			name:      "final flush failed",
+			flushErr:  errors.New("no space left on device"),
+			wantError: true,
+		},
+		{
+			name:      "close failed",
+			closeErr:  errors.New("connection reset"),
+			wantError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			sm := mocks.NewMockStorageManager(ctrl)
+			sm.EXPECT().Close().Return(tc.closeErr)
+
+			c := config.NewServerConfig()
+			app := &application{
+				storageManager: sm,
+				router:         chi.NewRouter(),
+				logger:         logger.NewLogger(),
+				config:         c,
+			}
+
+			runErr := make(chan error, 1)
+			stopped := false
+			stopRun := func() {
+				stopped = true
+				runErr <- tc.flushErr
+			}
+
+			err := app.shutdown(&http.Server{}, context.CancelFunc(stopRun), runErr)
+
+			assert.True(t, stopped, "storage manager was not stopped")
+			assert.Equal(t, tc.wantError, err != nil, "unexpected shutdown error %v", err)
+			if tc.flushErr != nil {
+				assert.ErrorIs(t, err, tc.flushErr)
+			}
+		})
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/h3ll0kitt1/observability/internal/auth"
+	"github.com/h3ll0kitt1/observability/internal/controller"
 	"github.com/h3ll0kitt1/observability/internal/hash"
+	"github.com/h3ll0kitt1/observability/internal/ratelimit"
 )
 
 type (
@@ -117,6 +124,16 @@ func (c *compressReader) Close() error {
 	return c.zr.Close()
 }
 
+func (app *application) bodyLimiter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if app.config.MaxBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, app.config.MaxBodySize)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) gzipper(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -124,15 +141,11 @@ func (app *application) gzipper(next http.Handler) http.Handler {
 		contentJSON := strings.Contains(contentType, "json")
 		contentText := strings.Contains(contentType, "text")
 
-		if !contentJSON && !contentText {
-			next.ServeHTTP(w, r)
-		}
-
 		ow := w
 		acceptEncoding := r.Header.Get("Accept-Encoding")
 		supportsGzip := strings.Contains(acceptEncoding, "gzip")
 
-		if supportsGzip {
+		if supportsGzip && (contentJSON || contentText) {
 			cw := newCompressWriter(w)
 			ow = cw
 			defer cw.Close()
@@ -144,11 +157,15 @@ func (app *application) gzipper(next http.Handler) http.Handler {
 		if sendsGzip {
 			cr, err := newCompressReader(r.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(decodeErrorStatus(err))
 				return
 			}
-			r.Body = cr
 			defer cr.Close()
+
+			r.Body = cr
+			if app.config.MaxDecompressedSize > 0 {
+				r.Body = http.MaxBytesReader(w, cr, app.config.MaxDecompressedSize)
+			}
 		}
 
 		next.ServeHTTP(ow, r)
@@ -161,14 +178,14 @@ func (app *application) requestVerifier(next http.Handler) http.Handler {
 		recievedHash := r.Header.Get("HashSHA256")
 
 		if app.config.Key != "" && recievedHash != "" {
-			ok, err := app.verifySignature(r.Body, recievedHash)
-
+			body, err := io.ReadAll(r.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(decodeErrorStatus(err))
 				return
 			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
 
-			if !ok {
+			if !app.verifySignature(body, recievedHash) {
 
 				app.logger.Infow("info",
 					"wrong hash signature", recievedHash,
@@ -182,22 +199,119 @@ func (app *application) requestVerifier(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) verifySignature(body io.ReadCloser, idealHash string) (bool, error) {
+func (app *application) verifySignature(body []byte, idealHash string) bool {
 
-	b, err := io.ReadAll(body)
-	if err != nil {
-		return false, err
-	}
-
-	computedHash := hash.ComputeSHA256(b, app.config.Key)
+	computedHash := hash.ComputeSHA256(body, app.config.Key)
 
 	app.logger.Infow("compare hashes",
 		"computed", computedHash,
 		"mustbe", idealHash,
 	)
 
-	if computedHash != idealHash {
-		return false, nil
+	return computedHash == idealHash
+}
+
+func (app *application) authorize(scope auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if app.tokens == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			raw, ok := bearerToken(r)
+			if !ok {
+				app.auditDenied(r, "", scope, "missing bearer token")
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			token, ok := app.tokens.Authenticate(raw)
+			if !ok {
+				app.auditDenied(r, "", scope, "invalid bearer token")
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !token.HasScope(scope) {
+				app.auditDenied(r, token.ID, scope, "insufficient scope")
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+		})
+	}
+}
+
+func (app *application) auditDenied(r *http.Request, tokenID string, scope auth.Scope, reason string) {
+	app.logger.Warnw("access denied",
+		"path", r.RequestURI,
+		"method", r.Method,
+		"remote", r.RemoteAddr,
+		"token", tokenID,
+		"scope", scope,
+		"reason", reason,
+	)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+func (app *application) rateLimit(l *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if l == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := clientIdentity(r)
+			allow, wait := l.Allow(client)
+			if !allow {
+				app.logger.Infow("rate limit exceeded",
+					"path", r.RequestURI,
+					"client", client,
+					"retry after", wait,
+				)
+
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// agentIdentity lets the controller count series created by the client
+// against per agent limit.
+func (app *application) agentIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := controller.WithAgent(r.Context(), clientIdentity(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func clientIdentity(r *http.Request) string {
+	if token, ok := auth.FromContext(r.Context()); ok {
+		return "token:" + token.ID
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
 	}
-	return true, nil
+	return "ip:" + host
 }
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/h3ll0kitt1/observability/internal/auth"
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/hash"
+	"github.com/h3ll0kitt1/observability/internal/logger"
+	"github.com/h3ll0kitt1/observability/internal/mocks"
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/ratelimit"
+)
+
+func TestMiddleware_authorize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	filename := filepath.Join(t.TempDir(), "tokens.json")
+	data := `[
+		{"id":"agent","token":"agent-secret","scopes":["write"]},
+		{"id":"dashboard","token":"dashboard-secret","scopes":["read"]},
+		{"id":"ops","token":"ops-secret","scopes":["admin"]}
+	]`
+	if err := os.WriteFile(filename, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := auth.NewTokens(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	c.TokensFile = filename
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+		tokens:         tokens,
+	}
+	app.setRouters()
+
+	srv := httptest.NewServer(app.router)
+	defer srv.Close()
+
+	sm.EXPECT().
+		Get(gomock.Any(), gomock.Any()).
+		Return(models.MetricsWithValue{ID: "testCounter", MType: "counter", Delta: 1}, nil).
+		AnyTimes()
+
+	sm.EXPECT().
+		Update(gomock.Any(), gomock.Any()).
+		Return(nil).
+		AnyTimes()
+
+	testCases := []struct {
+		name         string
+		path         string
+		method       string
+		token        string
+		expectedCode int
+	}{
+		{
+			name:         "read without token",
+			path:         "/value/counter/testCounter",
+			method:       http.MethodGet,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "read with unknown token",
+			path:         "/value/counter/testCounter",
+			method:       http.MethodGet,
+			token:        "guess",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "read with read token",
+			path:         "/value/counter/testCounter",
+			method:       http.MethodGet,
+			token:        "dashboard-secret",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "read with write token",
+			path:         "/value/counter/testCounter",
+			method:       http.MethodGet,
+			token:        "agent-secret",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "write with read token",
+			path:         "/update/counter/testCounter/1",
+			method:       http.MethodPost,
+			token:        "dashboard-secret",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "write with write token",
+			path:         "/update/counter/testCounter/1",
+			method:       http.MethodPost,
+			token:        "agent-secret",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "reload with write token",
+			path:         "/admin/tokens/reload",
+			method:       http.MethodPost,
+			token:        "agent-secret",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "reload with admin token",
+			path:         "/admin/tokens/reload",
+			method:       http.MethodPost,
+			token:        "ops-secret",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = tc.method
+			req.URL = srv.URL + tc.path
+
+			if tc.token != "" {
+				req.SetAuthToken(tc.token)
+			}
+
+			resp, err := req.Send()
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+		})
+	}
+}
+
+func TestMiddleware_rateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+		updateLimiter:  ratelimit.NewLimiter(0.001, 1),
+		updatesLimiter: ratelimit.NewLimiter(0.001, 1),
+	}
+	app.setRouters()
+
+	srv := httptest.NewServer(app.router)
+	defer srv.Close()
+
+	sm.EXPECT().
+		Update(gomock.Any(), gomock.Any()).
+		Return(nil).
+		Times(1)
+
+	sm.EXPECT().
+		UpdateList(gomock.Any(), gomock.Any()).
+		Return(nil).
+		Times(1)
+
+	testCases := []struct {
+		name         string
+		path         string
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "first update",
+			path:         "/update/counter/testCounter/1",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "second update",
+			path:         "/update/counter/testCounter/1",
+			expectedCode: http.StatusTooManyRequests,
+		},
+		{
+			name:         "first batch has own limit",
+			path:         "/updates/",
+			body:         `[{"id":"testCounter","type":"counter","delta":1}]`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "second batch",
+			path:         "/updates/",
+			body:         `[{"id":"testCounter","type":"counter","delta":1}]`,
+			expectedCode: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = http.MethodPost
+			req.URL = srv.URL + tc.path
+
+			if len(tc.body) > 0 {
+				req.SetHeader("Content-Type", "application/json")
+				req.SetBody(tc.body)
+			}
+
+			resp, err := req.Send()
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+			if tc.expectedCode == http.StatusTooManyRequests {
+				assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func gzipBody(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func metricsBatch(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"id":"testCounter%d","type":"counter","delta":1}`, i)
+	}
+	buf.WriteString("]")
+	return buf.Bytes()
+}
+
+func TestMiddleware_bodyLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sm := mocks.NewMockStorageManager(ctrl)
+
+	r := chi.NewRouter()
+	c := config.NewServerConfig()
+	c.Key = "secretkey"
+	c.MaxBodySize = 64 << 10
+	c.MaxDecompressedSize = 1 << 20
+	c.MaxBatchSize = 10
+	l := logger.NewLogger()
+
+	app := &application{
+		storageManager: sm,
+		router:         r,
+		logger:         l,
+		config:         c,
+	}
+	app.setRouters()
+
+	srv := httptest.NewServer(app.router)
+	defer srv.Close()
+
+	sm.EXPECT().
+		UpdateList(gomock.Any(), gomock.Len(10)).
+		Return(nil).
+		Times(2)
+
+	bomb := bytes.Repeat([]byte(" "), 16<<20)
+	bomb[0] = '['
+	bomb[len(bomb)-1] = ']'
+
+	noise := make([]byte, 128<<10)
+	rand.New(rand.NewSource(1)).Read(noise)
+	noisyBatch := []byte(fmt.Sprintf(`[{"id":"%x","type":"counter","delta":1}]`, noise))
+
+	testCases := []struct {
+		name         string
+		body         []byte
+		hash         string
+		expectedCode int
+	}{
+		{
+			name:         "batch within limits",
+			body:         gzipBody(t, metricsBatch(10)),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "signed batch within limits",
+			body:         gzipBody(t, metricsBatch(10)),
+			hash:         hash.ComputeSHA256(metricsBatch(10), "secretkey"),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "too many metrics",
+			body:         gzipBody(t, metricsBatch(11)),
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "decompression bomb",
+			body:         gzipBody(t, bomb),
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "signed decompression bomb",
+			body:         gzipBody(t, bomb),
+			hash:         "whatever",
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "compressed body too large",
+			body:         gzipBody(t, noisyBatch),
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = http.MethodPost
+			req.URL = srv.URL + "/updates/"
+			req.SetHeader("Content-Type", "application/json")
+			req.SetHeader("Content-Encoding", "gzip")
+			req.SetBody(tc.body)
+
+			if tc.hash != "" {
+				req.SetHeader("HashSHA256", tc.hash)
+			}
+
+			resp, err := req.Send()
+			assert.NoError(t, err, "error making HTTP request")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+		})
+	}
+}
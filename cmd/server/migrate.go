@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/schema"
+	"github.com/h3ll0kitt1/observability/internal/storage/sql"
+	"github.com/h3ll0kitt1/observability/internal/storage/sqlite"
+)
+
+var errMigrateUsage = errors.New("usage: server [flags] migrate up | down [steps] | status")
+
+type migrator interface {
+	Up(ctx context.Context) (int, error)
+	Down(ctx context.Context, steps int) (int, error)
+	Status(ctx context.Context) ([]schema.MigrationStatus, error)
+	Close() error
+}
+
+// openMigrator picks database from -d, or postgres or sqlite main storage.
+func openMigrator(cfg *config.ServerConfig) (migrator, error) {
+	if cfg.Database != "" {
+		return sql.OpenMigrator(cfg.Database)
+	}
+
+	u, err := url.Parse(cfg.MainStorage)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		return sql.OpenMigrator(cfg.MainStorage)
+	case "sqlite":
+		path := u.Opaque
+		if path == "" {
+			path = u.Host + u.Path
+		}
+		return sqlite.OpenMigrator(path)
+	}
+	return nil, errors.New("database is not set")
+}
+
+// runMigrate handles "migrate" subcommand.
+func runMigrate(cfg *config.ServerConfig, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errMigrateUsage
+		}
+		steps = n
+	case len(args) != 1:
+		return errMigrateUsage
+	}
+
+	m, err := openMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migrations\n", n)
+	case "down":
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reverted %d migrations\n", n)
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		return errMigrateUsage
+	}
+	return nil
+}
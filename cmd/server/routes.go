@@ -2,28 +2,43 @@ package main
 
 import (
 	"github.com/go-chi/chi/v5"
+
+	"github.com/h3ll0kitt1/observability/internal/auth"
 )
 
 func (app *application) setRouters() {
 
 	app.router.Use(app.requestLogger)
+	app.router.Use(app.bodyLimiter)
 	app.router.Use(app.gzipper)
 	app.router.Use(app.requestVerifier)
 
-	app.router.Route("/", func(r chi.Router) {
-		app.router.Get("/", app.getList)
-		app.router.Get("/ping", app.ping)
+	app.router.Get("/healthz", app.healthz)
+	app.router.Get("/readyz", app.readyz)
+
+	app.router.Group(func(r chi.Router) {
+		r.Use(app.authorize(auth.ScopeRead))
+
+		r.Get("/", app.getList)
+		r.Get("/ping", app.ping)
 
-		app.router.Route("/value", func(router chi.Router) {
+		r.Route("/value", func(router chi.Router) {
 			router.Post("/", app.getValue)
 			router.Get("/counter/{name}", app.getCounter)
 			router.Get("/gauge/{name}", app.getGauge)
 			router.Get("/{other}/{name}", errorUnknown)
 		})
+	})
+
+	app.router.Group(func(r chi.Router) {
+		r.Use(app.authorize(auth.ScopeWrite))
+		r.Use(app.agentIdentity)
 
-		app.router.Post("/updates/", app.updateList)
+		r.With(app.rateLimit(app.updatesLimiter)).Post("/updates/", app.updateList)
+
+		r.Route("/update", func(router chi.Router) {
+			router.Use(app.rateLimit(app.updateLimiter))
 
-		app.router.Route("/update", func(router chi.Router) {
 			router.Post("/", app.updateValue)
 
 			router.Route("/counter", func(router chi.Router) {
@@ -38,5 +53,13 @@ func (app *application) setRouters() {
 		})
 	})
 
+	app.router.Route("/admin", func(r chi.Router) {
+		r.Use(app.authorize(auth.ScopeAdmin))
+
+		r.Post("/tokens/reload", app.reloadTokens)
+		r.Get("/snapshots", app.listSnapshots)
+		r.Get("/cardinality", app.cardinality)
+	})
+
 	app.router.NotFound(errorNotFound)
 }
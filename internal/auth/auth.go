@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+)
+
+var (
+	ErrUnknownScope = errors.New("unknown token scope")
+	ErrEmptyToken   = errors.New("empty token")
+	ErrEmptyID      = errors.New("empty token id")
+	ErrDuplicateID  = errors.New("duplicate token id")
+)
+
+type Token struct {
+	ID     string  `json:"id"`
+	Token  string  `json:"token"`
+	Scopes []Scope `json:"scopes"`
+}
+
+type Tokens struct {
+	filename string
+	tokens   []Token
+	mu       sync.RWMutex
+}
+
+type ctxKey struct{}
+
+func NewTokens(filename string) (*Tokens, error) {
+	t := &Tokens{
+		filename: filename,
+	}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tokens) Reload() error {
+	data, err := os.ReadFile(t.filename)
+	if err != nil {
+		return err
+	}
+
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if token.ID == "" {
+			return ErrEmptyID
+		}
+		if ids[token.ID] {
+			return ErrDuplicateID
+		}
+		ids[token.ID] = true
+
+		if token.Token == "" {
+			return ErrEmptyToken
+		}
+		for _, scope := range token.Scopes {
+			if scope != ScopeRead && scope != ScopeWrite && scope != ScopeAdmin {
+				return ErrUnknownScope
+			}
+		}
+	}
+
+	t.mu.Lock()
+	t.tokens = tokens
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *Tokens) Authenticate(raw string) (Token, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var (
+		found Token
+		ok    bool
+	)
+
+	rawSum := sha256.Sum256([]byte(raw))
+	for _, token := range t.tokens {
+		tokenSum := sha256.Sum256([]byte(token.Token))
+		if subtle.ConstantTimeCompare(rawSum[:], tokenSum[:]) == 1 {
+			found = token
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+func (t Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func WithToken(ctx context.Context, token Token) context.Context {
+	return context.WithValue(ctx, ctxKey{}, token)
+}
+
+func FromContext(ctx context.Context) (Token, bool) {
+	token, ok := ctx.Value(ctxKey{}).(Token)
+	return token, ok
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func writeTokensFile(t *testing.T, data string) string {
+	file, err := os.CreateTemp(t.TempDir(), "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestTokens_Authenticate(t *testing.T) {
+	filename := writeTokensFile(t, `[
+		{"id":"agent","token":"agent-secret","scopes":["write"]},
+		{"id":"dashboard","token":"dashboard-secret","scopes":["read"]}
+	]`)
+
+	tokens, err := NewTokens(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		raw    string
+		wantID string
+		wantOk bool
+	}{
+		{
+			name:   "agent token",
+			raw:    "agent-secret",
+			wantID: "agent",
+			wantOk: true,
+		},
+		{
+			name:   "dashboard token",
+			raw:    "dashboard-secret",
+			wantID: "dashboard",
+			wantOk: true,
+		},
+		{
+			name:   "unknown token",
+			raw:    "agent-secre",
+			wantOk: false,
+		},
+		{
+			name:   "empty token",
+			raw:    "",
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tokens.Authenticate(tt.raw)
+			if ok != tt.wantOk {
+				t.Errorf("Authenticate() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got.ID != tt.wantID {
+				t.Errorf("Authenticate() id = %v, want %v", got.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestTokens_Reload(t *testing.T) {
+	filename := writeTokensFile(t, `[{"id":"agent","token":"old","scopes":["write"]}]`)
+
+	tokens, err := NewTokens(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filename, []byte(`[{"id":"agent","token":"new","scopes":["write"]}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := tokens.Authenticate("old"); ok {
+		t.Errorf("Authenticate() accepted token removed by Reload()")
+	}
+	if _, ok := tokens.Authenticate("new"); !ok {
+		t.Errorf("Authenticate() rejected token added by Reload()")
+	}
+
+	invalid := []struct {
+		name string
+		data string
+		want error
+	}{
+		{
+			name: "unknown scope",
+			data: `[{"id":"agent","token":"new","scopes":["root"]}]`,
+			want: ErrUnknownScope,
+		},
+		{
+			name: "empty token",
+			data: `[{"id":"agent","token":"","scopes":["write"]}]`,
+			want: ErrEmptyToken,
+		},
+		{
+			name: "empty id",
+			data: `[{"id":"","token":"new","scopes":["write"]}]`,
+			want: ErrEmptyID,
+		},
+		{
+			name: "duplicate id",
+			data: `[{"id":"agent","token":"new","scopes":["write"]},{"id":"agent","token":"other","scopes":["read"]}]`,
+			want: ErrDuplicateID,
+		},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filename, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := tokens.Reload(); err != tt.want {
+				t.Errorf("Reload() error = %v, want %v", err, tt.want)
+			}
+			if _, ok := tokens.Authenticate("new"); !ok {
+				t.Errorf("Authenticate() lost tokens after failed Reload()")
+			}
+		})
+	}
+}
+
+func TestToken_HasScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		token Token
+		scope Scope
+		want  bool
+	}{
+		{
+			name:  "read token reads",
+			token: Token{Scopes: []Scope{ScopeRead}},
+			scope: ScopeRead,
+			want:  true,
+		},
+		{
+			name:  "read token writes",
+			token: Token{Scopes: []Scope{ScopeRead}},
+			scope: ScopeWrite,
+			want:  false,
+		},
+		{
+			name:  "write token administers",
+			token: Token{Scopes: []Scope{ScopeRead, ScopeWrite}},
+			scope: ScopeAdmin,
+			want:  false,
+		},
+		{
+			name:  "admin token writes",
+			token: Token{Scopes: []Scope{ScopeAdmin}},
+			scope: ScopeWrite,
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.token.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Errorf("FromContext() found token in empty context")
+	}
+
+	ctx := WithToken(context.Background(), Token{ID: "agent"})
+	if got, ok := FromContext(ctx); !ok || got.ID != "agent" {
+		t.Errorf("FromContext() = %v, %v, want agent, true", got.ID, ok)
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"reflect"
 	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,6 +25,7 @@ import (
 	"github.com/h3ll0kitt1/observability/internal/config"
 	"github.com/h3ll0kitt1/observability/internal/hash"
 	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/retry"
 )
 
 var (
@@ -30,10 +34,19 @@ var (
 
 type customClient struct {
 	httpClient *resty.Client
+	policy     retry.Policy
 	endpoint   string
 	key        string
 }
 
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("server responded with %d %s", e.code, http.StatusText(e.code))
+}
+
 type metricKey struct {
 	id    string
 	mtype string
@@ -79,16 +92,54 @@ func Run(cfg *config.ClientConfig) {
 func newCustomClient(cfg *config.ClientConfig) customClient {
 	httpClient := resty.New()
 
-	httpClient.
-		SetRetryCount(cfg.RetryCount).
-		SetRetryWaitTime(cfg.RetryWaitTime).
-		SetRetryMaxWaitTime(cfg.RetryMaxWaitTime)
+	if cfg.Token != "" {
+		httpClient.SetAuthToken(cfg.Token)
+	}
 
 	return customClient{
 		httpClient: httpClient,
-		endpoint:   cfg.Endpoint,
-		key:        cfg.Key,
+		policy: retry.Policy{
+			Attempts:   cfg.RetryCount,
+			Wait:       cfg.RetryWaitTime,
+			Multiplier: 2,
+			MaxWait:    cfg.RetryMaxWaitTime,
+			MaxElapsed: cfg.RetryMaxElapsed,
+			Jitter:     0.2,
+			Retriable:  retriableRequest,
+		},
+		endpoint: cfg.Endpoint,
+		key:      cfg.Key,
+	}
+}
+
+// retriableRequest retries network errors and responses telling that the
+// server is overloaded or temporarily unavailable.
+func retriableRequest(err error) bool {
+	var statusErr statusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch statusErr.code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func retryAfter(r *resty.Response) time.Duration {
+	header := r.Header().Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
 	}
+	return 0
 }
 
 func (m *metrics) sendToServerWithRate(ctx context.Context, client customClient, limit int) {
@@ -132,16 +183,29 @@ func (c customClient) doRequestPOST(ctx context.Context, metric models.Metrics)
 		return errors.New("error compressing json to gzip")
 	}
 
-	_, err = c.httpClient.R().
-		SetContext(ctx).
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Content-Encoding", "gzip").
-		SetHeader("Accept-Encoding", "gzip").
-		SetBody(gzipData).
-		Post(c.endpoint + "/update/")
+	err = retry.Do(ctx, c.policy, func(ctx context.Context) error {
+		resp, err := c.httpClient.R().
+			SetContext(ctx).
+			SetHeader("Content-Type", "application/json").
+			SetHeader("Content-Encoding", "gzip").
+			SetHeader("Accept-Encoding", "gzip").
+			SetBody(gzipData).
+			Post(c.endpoint + "/update/")
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode() < http.StatusBadRequest {
+			return nil
+		}
+		err = statusError{code: resp.StatusCode()}
+		if retriableRequest(err) {
+			return retry.After(err, retryAfter(resp))
+		}
+		return err
+	})
 
 	if err != nil {
-		return ErrServerUnavailable
+		return fmt.Errorf("%w: %w", ErrServerUnavailable, err)
 	}
 	return nil
 }
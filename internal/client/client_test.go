@@ -2,8 +2,18 @@ package client
 
 import (
 	"context"
+	"errors"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
 )
 
 func TestMetrics_updateSpecificMemStats(t *testing.T) {
@@ -112,3 +122,149 @@ func TestGetFloat64(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryAfter(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{
+			name:   "seconds",
+			header: "3",
+			want:   3 * time.Second,
+		},
+		{
+			name:   "date in the past",
+			header: "Wed, 21 Oct 2015 07:28:00 GMT",
+			want:   0,
+		},
+		{
+			name:   "no header",
+			header: "",
+			want:   0,
+		},
+		{
+			name:   "garbage",
+			header: "soon",
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resty.Response{RawResponse: &http.Response{Header: http.Header{}}}
+			if tt.header != "" {
+				r.RawResponse.Header.Set("Retry-After", tt.header)
+			}
+
+			if got := retryAfter(r); got != tt.want {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomClient_doRequestPOST_retryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &config.ClientConfig{
+		Endpoint:         srv.URL,
+		RetryCount:       1,
+		RetryWaitTime:    10 * time.Millisecond,
+		RetryMaxWaitTime: 2 * time.Second,
+	}
+	c := newCustomClient(cfg)
+
+	value := float64(1)
+	start := time.Now()
+	if err := c.doRequestPOST(context.Background(), models.Metrics{ID: "g", MType: "gauge", Value: &value}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("doRequestPOST() made %d requests, want 2", got)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("doRequestPOST() retried after %v, want at least 1s", elapsed)
+	}
+}
+
+func TestCustomClient_doRequestPOST_retries(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantCalls int32
+		wantErr   bool
+	}{
+		{
+			name:      "service unavailable retried",
+			status:    http.StatusServiceUnavailable,
+			wantCalls: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "bad request not retried",
+			status:    http.StatusBadRequest,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "unauthorized not retried",
+			status:    http.StatusUnauthorized,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "internal server error not retried",
+			status:    http.StatusInternalServerError,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "ok",
+			status:    http.StatusOK,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			c := newCustomClient(&config.ClientConfig{
+				Endpoint:      srv.URL,
+				RetryCount:    2,
+				RetryWaitTime: time.Millisecond,
+			})
+
+			value := float64(1)
+			err := c.doRequestPOST(context.Background(), models.Metrics{ID: "g", MType: "gauge", Value: &value})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("doRequestPOST() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var statusErr statusError
+			if tt.wantErr && (!errors.Is(err, ErrServerUnavailable) || !errors.As(err, &statusErr) || statusErr.code != tt.status) {
+				t.Errorf("doRequestPOST() error = %v, want %v with status %d", err, ErrServerUnavailable, tt.status)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("doRequestPOST() made %d requests, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
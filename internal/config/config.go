@@ -12,21 +12,84 @@ type ClientConfig struct {
 	Addr             string
 	Endpoint         string
 	Key              string
+	Token            string
 	ReportInterval   time.Duration
 	PollInterval     time.Duration
 	RateLimit        int
 	RetryCount       int
 	RetryWaitTime    time.Duration
 	RetryMaxWaitTime time.Duration
+	RetryMaxElapsed  time.Duration
 }
 
 type ServerConfig struct {
 	Addr            string
 	Key             string
 	Database        string
+	DBCache         bool
+	DBCacheWindow   time.Duration
+	DBAutoMigrate   bool
+	MainStorage     string
+	BackupStorage   string
+	MemoryShards    int
 	FileStoragePath string
+	TokensFile      string
 	Restore         bool
+	RestoreFrom     string
 	StoreInterval   time.Duration
+	StaleThreshold  time.Duration
+	DegradeAfter    int
+	WALFile         string
+	WALSync         string
+	WALSyncInterval time.Duration
+
+	SnapshotInterval   time.Duration
+	SnapshotKeepLast   int
+	SnapshotKeepHourly int
+	SnapshotKeepDaily  int
+
+	BackupCompression string
+	BackupKey         string
+	BackupKeyFile     string
+	BackupCompact     int
+
+	DBPool              bool
+	DBMaxConns          int
+	DBMinConns          int
+	DBMaxConnLifetime   time.Duration
+	DBMaxConnIdleTime   time.Duration
+	DBHealthCheckPeriod time.Duration
+	DBStatementCache    int
+
+	DBHistory           bool
+	DBRollupMinuteAfter time.Duration
+	DBRollupHourAfter   time.Duration
+	DBHistoryRetention  time.Duration
+
+	ShutdownTimeout time.Duration
+	UpdateRate      float64
+	UpdateBurst     int
+	UpdatesRate     float64
+	UpdatesBurst    int
+
+	MaxBodySize         int64
+	MaxDecompressedSize int64
+	MaxBatchSize        int
+
+	MaxSeries         int
+	MaxSeriesPerAgent int
+	MaxNameLength     int
+	CardinalityPolicy string
+}
+
+type MigrateConfig struct {
+	From      string
+	To        string
+	Policy    string
+	DryRun    bool
+	BatchSize int
+
+	Storage ServerConfig
 }
 
 func NewClientConfig() *ClientConfig {
@@ -41,12 +104,14 @@ func (cc *ClientConfig) Parse() {
 		flagRunAddr        string
 		flagDatabase       string
 		flagKey            string
+		flagToken          string
 		flagRateLimit      int
 	)
 
 	flag.StringVar(&flagRunAddr, "a", "localhost:8080", "address and port to run client")
 	flag.StringVar(&flagDatabase, "d", "", "database to store metrics")
 	flag.StringVar(&flagKey, "k", "", "symmetrical key for SHA256 hash function")
+	flag.StringVar(&flagToken, "token", "", "bearer token to authorize requests to server")
 	flag.IntVar(&flagReportInterval, "r", 10, "number of seconds to report to server")
 	flag.IntVar(&flagPollInterval, "p", 2, "number of seconds to update metrics")
 	flag.IntVar(&flagRateLimit, "l", 2, "number of concurrent post requests to server")
@@ -64,6 +129,10 @@ func (cc *ClientConfig) Parse() {
 		flagKey = envKey
 	}
 
+	if envToken := os.Getenv("TOKEN"); envToken != "" {
+		flagToken = envToken
+	}
+
 	envReportInterval, err := strconv.Atoi(os.Getenv("REPORT_INTERVAL"))
 	if err == nil {
 		flagReportInterval = envReportInterval
@@ -83,23 +152,27 @@ func (cc *ClientConfig) Parse() {
 	addr := flagRunAddr
 	endpoint := protocol + addr
 	key := flagKey
+	token := flagToken
 	pollInterval := time.Duration(flagPollInterval) * time.Second
 	reportInterval := time.Duration(flagReportInterval) * time.Second
 	rateLimit := flagRateLimit
 	retryCount := 6
 	retryWaitTime := 3 * time.Second
 	retryMaxWaitTime := 90 * time.Second
+	retryMaxElapsed := 5 * time.Minute
 
 	cc.Protocol = protocol
 	cc.Addr = addr
 	cc.Endpoint = endpoint
 	cc.Key = key
+	cc.Token = token
 	cc.ReportInterval = reportInterval
 	cc.PollInterval = pollInterval
 	cc.RateLimit = rateLimit
 	cc.RetryCount = retryCount
 	cc.RetryWaitTime = retryWaitTime
 	cc.RetryMaxWaitTime = retryMaxWaitTime
+	cc.RetryMaxElapsed = retryMaxElapsed
 }
 
 func NewServerConfig() *ServerConfig {
@@ -112,17 +185,110 @@ func (sc *ServerConfig) Parse() {
 		flagRunAddr         string
 		flagFileStoragePath string
 		flagDatabasePath    string
+		flagDBCache         bool
+		flagDBCacheWindow   int
+		flagDBAutoMigrate   bool
+		flagMainStorage     string
+		flagBackupStorage   string
+		flagMemoryShards    int
 		flagKey             string
+		flagTokensFile      string
 		flagStoreInterval   int
 		flagRestore         bool
+		flagStaleThreshold  int
+		flagDegradeAfter    int
+		flagWALFile         string
+		flagWALSync         string
+		flagWALSyncInterval int
+		flagRestoreFrom     string
+
+		flagSnapshotInterval   int
+		flagSnapshotKeepLast   int
+		flagSnapshotKeepHourly int
+		flagSnapshotKeepDaily  int
+
+		flagBackupCompression string
+		flagBackupKeyFile     string
+		flagBackupCompact     int
+
+		flagDBPool              bool
+		flagDBMaxConns          int
+		flagDBMinConns          int
+		flagDBMaxConnLifetime   int
+		flagDBMaxConnIdleTime   int
+		flagDBHealthCheckPeriod int
+		flagDBStatementCache    int
+
+		flagDBHistory           bool
+		flagDBRollupMinuteAfter int
+		flagDBRollupHourAfter   int
+		flagDBHistoryRetention  int
+
+		flagShutdownTimeout int
+		flagUpdateRate      float64
+		flagUpdateBurst     int
+		flagUpdatesRate     float64
+		flagUpdatesBurst    int
+
+		flagMaxBodySize         int64
+		flagMaxDecompressedSize int64
+		flagMaxBatchSize        int
+
+		flagMaxSeries         int
+		flagMaxSeriesPerAgent int
+		flagMaxNameLength     int
+		flagCardinalityPolicy string
 	)
 
 	flag.StringVar(&flagRunAddr, "a", "localhost:8080", "address and port to run server")
 	flag.StringVar(&flagFileStoragePath, "f", "/tmp/metrics-db.json", "full name of file to save metrics")
 	flag.StringVar(&flagDatabasePath, "d", "", "sql database to store metrics")
+	flag.BoolVar(&flagDBCache, "db-cache", false, "serve reads from in-memory cache of sql database")
+	flag.IntVar(&flagDBCacheWindow, "db-cache-write-behind", 0, "number of seconds to collect updates in cache before writing them to sql database, 0 writes them through")
+	flag.BoolVar(&flagDBAutoMigrate, "db-auto-migrate", true, "apply pending sql schema migrations at start, otherwise refuse to start with outdated schema")
+	flag.BoolVar(&flagDBPool, "db-pool", false, "connect to sql database through native pgx pool instead of database/sql")
+	flag.IntVar(&flagDBMaxConns, "db-max-conns", 0, "maximum number of connections in pgx pool, 0 keeps pgx default")
+	flag.IntVar(&flagDBMinConns, "db-min-conns", 0, "minimum number of connections kept in pgx pool")
+	flag.IntVar(&flagDBMaxConnLifetime, "db-max-conn-lifetime", 0, "number of seconds after which pool connection is closed, 0 keeps pgx default")
+	flag.IntVar(&flagDBMaxConnIdleTime, "db-max-conn-idle-time", 0, "number of seconds after which idle pool connection is closed, 0 keeps pgx default")
+	flag.IntVar(&flagDBHealthCheckPeriod, "db-health-check-period", 0, "interval in seconds between health checks of idle pool connections, 0 keeps pgx default")
+	flag.IntVar(&flagDBStatementCache, "db-statement-cache", -1, "number of prepared statements cached per pool connection, 0 disables caching, negative keeps dsn or pgx default")
+	flag.BoolVar(&flagDBHistory, "db-history", false, "record every update of sql database as a sample with timestamp")
+	flag.IntVar(&flagDBRollupMinuteAfter, "db-rollup-1m-after", 3600, "age in seconds after which samples are rolled up into 1 minute aggregates")
+	flag.IntVar(&flagDBRollupHourAfter, "db-rollup-1h-after", 7*24*3600, "age in seconds after which 1 minute aggregates are rolled up into 1 hour ones")
+	flag.IntVar(&flagDBHistoryRetention, "db-history-retention", 30*24*3600, "age in seconds after which samples and aggregates are deleted")
+	flag.StringVar(&flagMainStorage, "main-storage", "", "url of main storage: memory://, bolt:///path, sqlite:///path, postgres://..., by default database from -d or memory")
+	flag.StringVar(&flagBackupStorage, "backup-storage", "", "url of backup storage: memory://, file:///path, bolt:///path, sqlite:///path, postgres://..., by default file from -f")
+	flag.IntVar(&flagMemoryShards, "memory-shards", 0, "number of lock-striped shards of in-memory main storage, 0 keeps one lock per metric type")
 	flag.StringVar(&flagKey, "k", "", "symmetrical key for SHA256 hash function")
+	flag.StringVar(&flagTokensFile, "tokens-file", "", "file with bearer tokens and their scopes, empty value disables authorization")
 	flag.IntVar(&flagStoreInterval, "i", 300, "interval in seconds to store metric values to file")
 	flag.BoolVar(&flagRestore, "r", true, "bool value to show if previosly saved metrics should be loaded into server memory")
+	flag.IntVar(&flagStaleThreshold, "backup-stale-threshold", 900, "age in seconds after which backup is considered stale and server not ready, 0 disables check")
+	flag.IntVar(&flagDegradeAfter, "degrade-after", 0, "number of consecutive failed backup flushes after which updates are rejected, 0 disables read-only mode")
+	flag.StringVar(&flagRestoreFrom, "restore-from", "", "name of snapshot next to backup file or absolute path to load at start instead of latest backup")
+	flag.IntVar(&flagSnapshotInterval, "snapshot-interval", 300, "min number of seconds between timestamped snapshots of backup file")
+	flag.IntVar(&flagSnapshotKeepLast, "snapshot-keep-last", 0, "number of latest snapshots to keep")
+	flag.IntVar(&flagSnapshotKeepHourly, "snapshot-keep-hourly", 0, "number of hours to keep newest snapshot for")
+	flag.IntVar(&flagSnapshotKeepDaily, "snapshot-keep-daily", 0, "number of days to keep newest snapshot for")
+	flag.StringVar(&flagBackupCompression, "backup-compression", "none", "compression of backup file and snapshots: none, gzip or zstd")
+	flag.StringVar(&flagBackupKeyFile, "backup-key-file", "", "file with hex encoded AES key (16, 24 or 32 bytes) to encrypt backup file and snapshots")
+	flag.IntVar(&flagBackupCompact, "backup-compact-after", 10000, "number of changed metrics appended to backup delta file before backup is rewritten")
+	flag.StringVar(&flagWALFile, "wal-file", "", "base name of write-ahead log files for async backup mode, empty value disables log")
+	flag.StringVar(&flagWALSync, "wal-sync", "always", "when to fsync write-ahead log: always, interval or never")
+	flag.IntVar(&flagWALSyncInterval, "wal-sync-interval", 1, "number of seconds between background fsyncs of write-ahead log in interval mode")
+	flag.IntVar(&flagShutdownTimeout, "shutdown-timeout", 30, "number of seconds to wait for in-flight requests on shutdown")
+	flag.Float64Var(&flagUpdateRate, "update-rate", 0, "requests per second allowed for each client on /update/ routes, 0 disables limit")
+	flag.IntVar(&flagUpdateBurst, "update-burst", 100, "number of requests each client can burst on /update/ routes")
+	flag.Float64Var(&flagUpdatesRate, "updates-rate", 0, "requests per second allowed for each client on /updates/ route, 0 disables limit")
+	flag.IntVar(&flagUpdatesBurst, "updates-burst", 10, "number of requests each client can burst on /updates/ route")
+	flag.Int64Var(&flagMaxBodySize, "max-body-size", 1<<20, "max size in bytes of request body as received, 0 disables limit")
+	flag.Int64Var(&flagMaxDecompressedSize, "max-decompressed-size", 8<<20, "max size in bytes of request body after gzip decompression, 0 disables limit")
+	flag.IntVar(&flagMaxBatchSize, "max-batch-size", 10000, "max number of metrics in one /updates/ request, 0 disables limit")
+	flag.IntVar(&flagMaxSeries, "max-series", 0, "max number of stored series, 0 disables limit")
+	flag.IntVar(&flagMaxSeriesPerAgent, "max-series-per-agent", 0, "max number of series created by one agent, 0 disables limit")
+	flag.IntVar(&flagMaxNameLength, "max-name-length", 0, "max length of metric name in bytes, 0 disables limit")
+	flag.StringVar(&flagCardinalityPolicy, "cardinality-policy", "reject", "what to do with metrics over limits: reject update with 422 or drop them")
 	flag.Parse()
 
 	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
@@ -137,10 +303,97 @@ func (sc *ServerConfig) Parse() {
 		flagDatabasePath = envDatabasePath
 	}
 
+	envDBCache, err := strconv.ParseBool(os.Getenv("DB_CACHE"))
+	if err == nil {
+		flagDBCache = envDBCache
+	}
+
+	envDBCacheWindow, err := strconv.Atoi(os.Getenv("DB_CACHE_WRITE_BEHIND"))
+	if err == nil {
+		flagDBCacheWindow = envDBCacheWindow
+	}
+
+	envDBAutoMigrate, err := strconv.ParseBool(os.Getenv("DB_AUTO_MIGRATE"))
+	if err == nil {
+		flagDBAutoMigrate = envDBAutoMigrate
+	}
+
+	envDBPool, err := strconv.ParseBool(os.Getenv("DB_POOL"))
+	if err == nil {
+		flagDBPool = envDBPool
+	}
+
+	envDBMaxConns, err := strconv.Atoi(os.Getenv("DB_MAX_CONNS"))
+	if err == nil {
+		flagDBMaxConns = envDBMaxConns
+	}
+
+	envDBMinConns, err := strconv.Atoi(os.Getenv("DB_MIN_CONNS"))
+	if err == nil {
+		flagDBMinConns = envDBMinConns
+	}
+
+	envDBMaxConnLifetime, err := strconv.Atoi(os.Getenv("DB_MAX_CONN_LIFETIME"))
+	if err == nil {
+		flagDBMaxConnLifetime = envDBMaxConnLifetime
+	}
+
+	envDBMaxConnIdleTime, err := strconv.Atoi(os.Getenv("DB_MAX_CONN_IDLE_TIME"))
+	if err == nil {
+		flagDBMaxConnIdleTime = envDBMaxConnIdleTime
+	}
+
+	envDBHealthCheckPeriod, err := strconv.Atoi(os.Getenv("DB_HEALTH_CHECK_PERIOD"))
+	if err == nil {
+		flagDBHealthCheckPeriod = envDBHealthCheckPeriod
+	}
+
+	envDBStatementCache, err := strconv.Atoi(os.Getenv("DB_STATEMENT_CACHE"))
+	if err == nil {
+		flagDBStatementCache = envDBStatementCache
+	}
+
+	envDBHistory, err := strconv.ParseBool(os.Getenv("DB_HISTORY"))
+	if err == nil {
+		flagDBHistory = envDBHistory
+	}
+
+	envDBRollupMinuteAfter, err := strconv.Atoi(os.Getenv("DB_ROLLUP_1M_AFTER"))
+	if err == nil {
+		flagDBRollupMinuteAfter = envDBRollupMinuteAfter
+	}
+
+	envDBRollupHourAfter, err := strconv.Atoi(os.Getenv("DB_ROLLUP_1H_AFTER"))
+	if err == nil {
+		flagDBRollupHourAfter = envDBRollupHourAfter
+	}
+
+	envDBHistoryRetention, err := strconv.Atoi(os.Getenv("DB_HISTORY_RETENTION"))
+	if err == nil {
+		flagDBHistoryRetention = envDBHistoryRetention
+	}
+
+	if envMainStorage := os.Getenv("MAIN_STORAGE"); envMainStorage != "" {
+		flagMainStorage = envMainStorage
+	}
+
+	if envBackupStorage := os.Getenv("BACKUP_STORAGE"); envBackupStorage != "" {
+		flagBackupStorage = envBackupStorage
+	}
+
+	envMemoryShards, err := strconv.Atoi(os.Getenv("MEMORY_SHARDS"))
+	if err == nil {
+		flagMemoryShards = envMemoryShards
+	}
+
 	if envKey := os.Getenv("KEY"); envKey != "" {
 		flagKey = envKey
 	}
 
+	if envTokensFile := os.Getenv("TOKENS_FILE"); envTokensFile != "" {
+		flagTokensFile = envTokensFile
+	}
+
 	envRestore, err := strconv.ParseBool(os.Getenv("RESTORE"))
 	if err == nil {
 		flagRestore = envRestore
@@ -151,17 +404,281 @@ func (sc *ServerConfig) Parse() {
 		flagStoreInterval = envStoreInterval
 	}
 
+	envStaleThreshold, err := strconv.Atoi(os.Getenv("BACKUP_STALE_THRESHOLD"))
+	if err == nil {
+		flagStaleThreshold = envStaleThreshold
+	}
+
+	envDegradeAfter, err := strconv.Atoi(os.Getenv("DEGRADE_AFTER"))
+	if err == nil {
+		flagDegradeAfter = envDegradeAfter
+	}
+
+	if envRestoreFrom := os.Getenv("RESTORE_FROM"); envRestoreFrom != "" {
+		flagRestoreFrom = envRestoreFrom
+	}
+
+	envSnapshotInterval, err := strconv.Atoi(os.Getenv("SNAPSHOT_INTERVAL"))
+	if err == nil {
+		flagSnapshotInterval = envSnapshotInterval
+	}
+
+	envSnapshotKeepLast, err := strconv.Atoi(os.Getenv("SNAPSHOT_KEEP_LAST"))
+	if err == nil {
+		flagSnapshotKeepLast = envSnapshotKeepLast
+	}
+
+	envSnapshotKeepHourly, err := strconv.Atoi(os.Getenv("SNAPSHOT_KEEP_HOURLY"))
+	if err == nil {
+		flagSnapshotKeepHourly = envSnapshotKeepHourly
+	}
+
+	envSnapshotKeepDaily, err := strconv.Atoi(os.Getenv("SNAPSHOT_KEEP_DAILY"))
+	if err == nil {
+		flagSnapshotKeepDaily = envSnapshotKeepDaily
+	}
+
+	if envBackupCompression := os.Getenv("BACKUP_COMPRESSION"); envBackupCompression != "" {
+		flagBackupCompression = envBackupCompression
+	}
+
+	if envBackupKeyFile := os.Getenv("BACKUP_KEY_FILE"); envBackupKeyFile != "" {
+		flagBackupKeyFile = envBackupKeyFile
+	}
+
+	envBackupCompact, err := strconv.Atoi(os.Getenv("BACKUP_COMPACT_AFTER"))
+	if err == nil {
+		flagBackupCompact = envBackupCompact
+	}
+
+	if envWALFile := os.Getenv("WAL_FILE"); envWALFile != "" {
+		flagWALFile = envWALFile
+	}
+
+	if envWALSync := os.Getenv("WAL_SYNC"); envWALSync != "" {
+		flagWALSync = envWALSync
+	}
+
+	envWALSyncInterval, err := strconv.Atoi(os.Getenv("WAL_SYNC_INTERVAL"))
+	if err == nil {
+		flagWALSyncInterval = envWALSyncInterval
+	}
+
+	envShutdownTimeout, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err == nil {
+		flagShutdownTimeout = envShutdownTimeout
+	}
+
+	envUpdateRate, err := strconv.ParseFloat(os.Getenv("UPDATE_RATE"), 64)
+	if err == nil {
+		flagUpdateRate = envUpdateRate
+	}
+
+	envUpdateBurst, err := strconv.Atoi(os.Getenv("UPDATE_BURST"))
+	if err == nil {
+		flagUpdateBurst = envUpdateBurst
+	}
+
+	envUpdatesRate, err := strconv.ParseFloat(os.Getenv("UPDATES_RATE"), 64)
+	if err == nil {
+		flagUpdatesRate = envUpdatesRate
+	}
+
+	envUpdatesBurst, err := strconv.Atoi(os.Getenv("UPDATES_BURST"))
+	if err == nil {
+		flagUpdatesBurst = envUpdatesBurst
+	}
+
+	envMaxBodySize, err := strconv.ParseInt(os.Getenv("MAX_BODY_SIZE"), 10, 64)
+	if err == nil {
+		flagMaxBodySize = envMaxBodySize
+	}
+
+	envMaxDecompressedSize, err := strconv.ParseInt(os.Getenv("MAX_DECOMPRESSED_SIZE"), 10, 64)
+	if err == nil {
+		flagMaxDecompressedSize = envMaxDecompressedSize
+	}
+
+	envMaxBatchSize, err := strconv.Atoi(os.Getenv("MAX_BATCH_SIZE"))
+	if err == nil {
+		flagMaxBatchSize = envMaxBatchSize
+	}
+
+	envMaxSeries, err := strconv.Atoi(os.Getenv("MAX_SERIES"))
+	if err == nil {
+		flagMaxSeries = envMaxSeries
+	}
+
+	envMaxSeriesPerAgent, err := strconv.Atoi(os.Getenv("MAX_SERIES_PER_AGENT"))
+	if err == nil {
+		flagMaxSeriesPerAgent = envMaxSeriesPerAgent
+	}
+
+	envMaxNameLength, err := strconv.Atoi(os.Getenv("MAX_NAME_LENGTH"))
+	if err == nil {
+		flagMaxNameLength = envMaxNameLength
+	}
+
+	if envCardinalityPolicy := os.Getenv("CARDINALITY_POLICY"); envCardinalityPolicy != "" {
+		flagCardinalityPolicy = envCardinalityPolicy
+	}
+
 	addr := flagRunAddr
 	file := flagFileStoragePath
 	storeInterval := time.Duration(flagStoreInterval) * time.Second
 	restore := flagRestore
 	database := flagDatabasePath
+	dbCache := flagDBCache
+	dbCacheWindow := time.Duration(flagDBCacheWindow) * time.Second
+	dbAutoMigrate := flagDBAutoMigrate
+	dbPool := flagDBPool
+	dbMaxConns := flagDBMaxConns
+	dbMinConns := flagDBMinConns
+	dbMaxConnLifetime := time.Duration(flagDBMaxConnLifetime) * time.Second
+	dbMaxConnIdleTime := time.Duration(flagDBMaxConnIdleTime) * time.Second
+	dbHealthCheckPeriod := time.Duration(flagDBHealthCheckPeriod) * time.Second
+	dbStatementCache := flagDBStatementCache
+	dbHistory := flagDBHistory
+	dbRollupMinuteAfter := time.Duration(flagDBRollupMinuteAfter) * time.Second
+	dbRollupHourAfter := time.Duration(flagDBRollupHourAfter) * time.Second
+	dbHistoryRetention := time.Duration(flagDBHistoryRetention) * time.Second
+	mainStorage := flagMainStorage
+	backupStorage := flagBackupStorage
+	memoryShards := flagMemoryShards
 	key := flagKey
+	tokensFile := flagTokensFile
+	staleThreshold := time.Duration(flagStaleThreshold) * time.Second
+	degradeAfter := flagDegradeAfter
+	shutdownTimeout := time.Duration(flagShutdownTimeout) * time.Second
+	walFile := flagWALFile
+	restoreFrom := flagRestoreFrom
+	snapshotInterval := time.Duration(flagSnapshotInterval) * time.Second
+	snapshotKeepLast := flagSnapshotKeepLast
+	snapshotKeepHourly := flagSnapshotKeepHourly
+	snapshotKeepDaily := flagSnapshotKeepDaily
+	backupCompression := flagBackupCompression
+	// There is no flag for the key itself, it would be seen in ps output
+	// and shell history.
+	backupKey := os.Getenv("BACKUP_KEY")
+	backupKeyFile := flagBackupKeyFile
+	backupCompact := flagBackupCompact
+	walSync := flagWALSync
+	walSyncInterval := time.Duration(flagWALSyncInterval) * time.Second
+	updateRate := flagUpdateRate
+	updateBurst := flagUpdateBurst
+	updatesRate := flagUpdatesRate
+	updatesBurst := flagUpdatesBurst
+	maxBodySize := flagMaxBodySize
+	maxDecompressedSize := flagMaxDecompressedSize
+	maxBatchSize := flagMaxBatchSize
+	maxSeries := flagMaxSeries
+	maxSeriesPerAgent := flagMaxSeriesPerAgent
+	maxNameLength := flagMaxNameLength
+	cardinalityPolicy := flagCardinalityPolicy
 
 	sc.Addr = addr
 	sc.StoreInterval = storeInterval
 	sc.FileStoragePath = file
 	sc.Restore = restore
 	sc.Database = database
+	sc.DBCache = dbCache
+	sc.DBCacheWindow = dbCacheWindow
+	sc.DBAutoMigrate = dbAutoMigrate
+	sc.DBPool = dbPool
+	sc.DBMaxConns = dbMaxConns
+	sc.DBMinConns = dbMinConns
+	sc.DBMaxConnLifetime = dbMaxConnLifetime
+	sc.DBMaxConnIdleTime = dbMaxConnIdleTime
+	sc.DBHealthCheckPeriod = dbHealthCheckPeriod
+	sc.DBStatementCache = dbStatementCache
+	sc.DBHistory = dbHistory
+	sc.DBRollupMinuteAfter = dbRollupMinuteAfter
+	sc.DBRollupHourAfter = dbRollupHourAfter
+	sc.DBHistoryRetention = dbHistoryRetention
+	sc.MainStorage = mainStorage
+	sc.BackupStorage = backupStorage
+	sc.MemoryShards = memoryShards
 	sc.Key = key
+	sc.TokensFile = tokensFile
+	sc.StaleThreshold = staleThreshold
+	sc.DegradeAfter = degradeAfter
+	sc.ShutdownTimeout = shutdownTimeout
+	sc.WALFile = walFile
+	sc.RestoreFrom = restoreFrom
+	sc.SnapshotInterval = snapshotInterval
+	sc.SnapshotKeepLast = snapshotKeepLast
+	sc.SnapshotKeepHourly = snapshotKeepHourly
+	sc.SnapshotKeepDaily = snapshotKeepDaily
+	sc.BackupCompression = backupCompression
+	sc.BackupKey = backupKey
+	sc.BackupKeyFile = backupKeyFile
+	sc.BackupCompact = backupCompact
+	sc.WALSync = walSync
+	sc.WALSyncInterval = walSyncInterval
+	sc.UpdateRate = updateRate
+	sc.UpdateBurst = updateBurst
+	sc.UpdatesRate = updatesRate
+	sc.UpdatesBurst = updatesBurst
+	sc.MaxBodySize = maxBodySize
+	sc.MaxDecompressedSize = maxDecompressedSize
+	sc.MaxBatchSize = maxBatchSize
+	sc.MaxSeries = maxSeries
+	sc.MaxSeriesPerAgent = maxSeriesPerAgent
+	sc.MaxNameLength = maxNameLength
+	sc.CardinalityPolicy = cardinalityPolicy
+}
+
+func NewMigrateConfig() *MigrateConfig {
+	var mc MigrateConfig
+	return &mc
+}
+
+func (mc *MigrateConfig) Parse() {
+	var (
+		flagFrom      string
+		flagTo        string
+		flagPolicy    string
+		flagDryRun    bool
+		flagBatchSize int
+
+		flagBackupCompression string
+		flagBackupKeyFile     string
+	)
+
+	flag.StringVar(&flagFrom, "from", "", "url of storage to read metrics from: file:///path, bolt:///path, sqlite:///path, postgres://...")
+	flag.StringVar(&flagTo, "to", "", "url of storage to write metrics to: file:///path, bolt:///path, sqlite:///path, postgres://...")
+	flag.StringVar(&flagPolicy, "policy", "overwrite", "how to treat metrics present in both storages: overwrite or merge")
+	flag.BoolVar(&flagDryRun, "dry-run", false, "only report what would be written")
+	flag.IntVar(&flagBatchSize, "batch-size", 1000, "number of metrics written to database at once")
+	flag.StringVar(&flagBackupCompression, "backup-compression", "none", "compression of written backup file: none, gzip or zstd")
+	flag.StringVar(&flagBackupKeyFile, "backup-key-file", "", "file with hex encoded AES key of backup files")
+	flag.Parse()
+
+	if envFrom := os.Getenv("MIGRATE_FROM"); envFrom != "" {
+		flagFrom = envFrom
+	}
+
+	if envTo := os.Getenv("MIGRATE_TO"); envTo != "" {
+		flagTo = envTo
+	}
+
+	if envBackupKeyFile := os.Getenv("BACKUP_KEY_FILE"); envBackupKeyFile != "" {
+		flagBackupKeyFile = envBackupKeyFile
+	}
+
+	from := flagFrom
+	to := flagTo
+	policy := flagPolicy
+	dryRun := flagDryRun
+	batchSize := flagBatchSize
+
+	mc.From = from
+	mc.To = to
+	mc.Policy = policy
+	mc.DryRun = dryRun
+	mc.BatchSize = batchSize
+	mc.Storage.DBAutoMigrate = true
+	mc.Storage.BackupCompression = flagBackupCompression
+	mc.Storage.BackupKey = os.Getenv("BACKUP_KEY")
+	mc.Storage.BackupKeyFile = flagBackupKeyFile
 }
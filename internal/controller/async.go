@@ -2,19 +2,44 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
+	"go.uber.org/zap"
+
 	"github.com/h3ll0kitt1/observability/internal/models"
 )
 
+const flushRetryWait = time.Second
+
 type AsyncController struct {
-	time    time.Duration
-	backup  BackupStorage
-	storage MainStorage
+	time      time.Duration
+	retryWait time.Duration
+	backup    BackupStorage
+	storage   MainStorage
+	restore   BackupStorage
+	wal       WriteAheadLog
+	dirty     dirtySet
+	mu        sync.RWMutex
+	statusTracker
+	cardinalityTracker
 }
 
 func (c *AsyncController) Load() error {
-	list, err := c.backup.GetList(context.Background())
+	err := c.load()
+	c.reseed()
+	c.restored(err)
+	return err
+}
+
+func (c *AsyncController) load() error {
+	source := c.backup
+	if c.restore != nil {
+		source = c.restore
+	}
+
+	list, err := source.GetList(context.Background())
 	if err != nil {
 		return err
 	}
@@ -22,18 +47,91 @@ func (c *AsyncController) Load() error {
 	if err := c.storage.UpdateList(context.Background(), list); err != nil {
 		return err
 	}
-	return nil
+
+	// The log holds updates made after the latest backup, they must not be
+	// applied on top of an older snapshot chosen for restore.
+	if c.wal == nil || c.restore != nil {
+		return nil
+	}
+
+	checkpoint, err := c.backup.(CheckpointBackup).Checkpoint(context.Background())
+	if err != nil {
+		return err
+	}
+	list, err = c.wal.Replay(checkpoint)
+	if err != nil {
+		return err
+	}
+	return c.storage.UpdateList(context.Background(), list)
 }
 
-func (c *AsyncController) Run() {
+// Run flushes the backup every c.time. A failed flush is retried with
+// growing waits until it succeeds, the outcome is reported through Status.
+func (c *AsyncController) Run(ctx context.Context) error {
 	ticker := time.NewTicker(c.time)
-	for range ticker.C {
-		c.flush()
+	defer ticker.Stop()
+
+	var (
+		retry <-chan time.Time
+		wait  time.Duration
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return c.flush()
+		case <-ticker.C:
+		case <-retry:
+		}
+
+		if err := c.flush(); err != nil {
+			wait = c.nextRetryWait(wait)
+			retry = time.After(wait)
+			continue
+		}
+		retry = nil
+		wait = 0
+	}
+}
+
+func (c *AsyncController) nextRetryWait(wait time.Duration) time.Duration {
+	switch {
+	case wait == 0 && c.retryWait > 0:
+		wait = c.retryWait
+	case wait == 0:
+		wait = flushRetryWait
+	default:
+		wait *= 2
+	}
+	if wait > c.time {
+		wait = c.time
 	}
+	return wait
+}
+
+func (c *AsyncController) Snapshots() ([]models.Snapshot, error) {
+	return listSnapshots(c.backup)
+}
+
+func (c *AsyncController) CacheStats() (CacheStats, bool) {
+	return cacheStats(c.storage)
+}
+
+func (c *AsyncController) PoolStats() (PoolStats, bool) {
+	return poolStats(c.storage)
+}
+
+func (c *AsyncController) Cardinality(ctx context.Context, prefix string) (Cardinality, error) {
+	return c.report(ctx, c.storage, prefix)
+}
+
+func (c *AsyncController) SetLogger(logger *zap.SugaredLogger) {
+	c.statusTracker.SetLogger(logger)
+	setStorageLogger(c.storage, logger)
 }
 
 func (c *AsyncController) Set(newMainStorage MainStorage) {
 	c.storage = newMainStorage
+	c.reseed()
 }
 
 func (c *AsyncController) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
@@ -53,23 +151,72 @@ func (c *AsyncController) GetList(ctx context.Context) ([]models.MetricsWithValu
 }
 
 func (c *AsyncController) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	if c.degraded() {
+		return ErrDegraded
+	}
+
+	accepted, claimed, err := c.admit(ctx, c.storage, []models.MetricsWithValue{metric})
+	if err != nil || len(accepted) == 0 {
+		return err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	if err := c.storage.Update(ctx, metric); err != nil {
+		c.forget(claimed)
 		return err
 	}
-	return nil
+	c.confirm(claimed)
+	c.dirty.mark(metric)
+	return c.appendLog(accepted)
 }
 
 func (c *AsyncController) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	if c.degraded() {
+		return ErrDegraded
+	}
+
+	list, claimed, err := c.admit(ctx, c.storage, list)
+	if err != nil || len(list) == 0 {
+		return err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	if err := c.storage.UpdateList(ctx, list); err != nil {
+		c.forget(claimed)
 		return err
 	}
-	return nil
+	c.confirm(claimed)
+	c.dirty.mark(list...)
+	return c.appendLog(list)
+}
+
+// appendLog records an update already applied to storage, so that a failed
+// update never gets into the log and is replayed after a crash. The caller
+// holds c.mu for reading, so flush sees the update both in storage and in
+// the log or in neither.
+func (c *AsyncController) appendLog(list []models.MetricsWithValue) error {
+	if c.wal == nil {
+		return nil
+	}
+	return c.wal.Append(list)
 }
 
 func (c *AsyncController) Ping() error {
 	return c.storage.Ping()
 }
 
+func (c *AsyncController) Close() error {
+	err := errors.Join(c.storage.Close(), c.backup.Close())
+	if c.wal != nil {
+		err = errors.Join(err, c.wal.Close())
+	}
+	return err
+}
+
 func (c *AsyncController) SetRetryCount(attempts int) {
 	c.storage.SetRetryCount(attempts)
 	c.backup.SetRetryCount(attempts)
@@ -86,13 +233,48 @@ func (c *AsyncController) SetRetryIncreaseWaitTime(delta time.Duration) {
 }
 
 func (c *AsyncController) flush() error {
-	list, err := c.storage.GetList(context.Background())
+	err := c.writeBackup()
+	c.flushed(err)
+	return err
+}
+
+func (c *AsyncController) writeBackup() error {
+	ctx := context.Background()
+
+	if c.wal == nil {
+		keys, incremental := c.dirty.take()
+		list, err := backupList(ctx, c.storage, keys, incremental)
+		if err == nil {
+			err = storeBackup(ctx, c.backup, list, incremental)
+		}
+		c.dirty.done(keys, err)
+		return err
+	}
+
+	// Updates are blocked only while the snapshot is taken and the log is
+	// rotated, so the snapshot contains exactly the rotated segments.
+	c.mu.Lock()
+	keys, incremental := c.dirty.take()
+	list, err := backupList(ctx, c.storage, keys, incremental)
 	if err != nil {
+		c.mu.Unlock()
+		c.dirty.done(keys, err)
+		return err
+	}
+	seq, err := c.wal.Rotate()
+	c.mu.Unlock()
+	if err != nil {
+		c.dirty.done(keys, err)
 		return err
 	}
 
-	if err := c.backup.UpdateList(context.Background(), list); err != nil {
+	// The checkpoint is written with the backup, a crash before Commit
+	// leaves segments that replay skips.
+	c.backup.(CheckpointBackup).SetCheckpoint(seq)
+	err = storeBackup(ctx, c.backup, list, incremental)
+	c.dirty.done(keys, err)
+	if err != nil {
 		return err
 	}
-	return nil
+	return c.wal.Commit(seq)
 }
@@ -0,0 +1,369 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/file"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+func TestAsyncController_Run(t *testing.T) {
+	backup := file.NewStorage(filepath.Join(t.TempDir(), "metrics-db.json"))
+
+	c := &AsyncController{
+		time:          time.Hour,
+		storage:       inmemory.NewStorage(),
+		backup:        backup,
+		statusTracker: newStatusTracker(time.Hour),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	metric := models.MetricsWithValue{ID: "testCounter", MType: "counter", Delta: 1}
+	if err := c.Update(context.Background(), metric); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run() = %v, want nil", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run() did not return after context cancellation")
+	}
+
+	list, err := backup.GetList(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != metric {
+		t.Errorf("backup after Run() = %v, want [%v]", list, metric)
+	}
+
+	if c.Status().LastFlush.IsZero() {
+		t.Errorf("Status().LastFlush not set after final flush")
+	}
+}
+
+func TestAsyncController_Load_replaysWAL(t *testing.T) {
+	dir := t.TempDir()
+	backupFile := filepath.Join(dir, "metrics-db.json")
+	walFile := filepath.Join(dir, "metrics-db.wal")
+
+	newController := func() *AsyncController {
+		wal, err := file.OpenWAL(walFile, file.SyncAlways, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &AsyncController{
+			time:          time.Hour,
+			storage:       inmemory.NewStorage(),
+			backup:        file.NewStorage(backupFile),
+			wal:           wal,
+			statusTracker: newStatusTracker(time.Hour),
+		}
+	}
+
+	ctx := context.Background()
+	counter := models.MetricsWithValue{ID: "testCounter", MType: "counter", Delta: 2}
+
+	c := newController()
+	c.Update(ctx, counter)
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	c.UpdateList(ctx, []models.MetricsWithValue{counter, counter})
+	// crash: neither flush nor Close are called
+
+	restarted := newController()
+	defer restarted.Close()
+	if err := restarted.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := restarted.Get(ctx, models.MetricsWithValue{ID: "testCounter", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Delta != 6 {
+		t.Errorf("Get() after restart = %d, want 6", got.Delta)
+	}
+}
+
+func TestAsyncController_failedUpdateNotLogged(t *testing.T) {
+	dir := t.TempDir()
+	backupFile := filepath.Join(dir, "metrics-db.json")
+	walFile := filepath.Join(dir, "metrics-db.wal")
+
+	wal, err := file.OpenWAL(walFile, file.SyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := newBackingStorage()
+	c := &AsyncController{
+		time:          time.Hour,
+		storage:       storage,
+		backup:        file.NewStorage(backupFile),
+		wal:           wal,
+		statusTracker: newStatusTracker(time.Hour),
+	}
+
+	ctx := context.Background()
+	counter := models.MetricsWithValue{ID: "testCounter", MType: "counter", Delta: 2}
+	if err := c.Update(ctx, counter); err != nil {
+		t.Fatal(err)
+	}
+	storage.fail.Store(true)
+	if err := c.UpdateList(ctx, []models.MetricsWithValue{counter}); err == nil {
+		t.Fatal("UpdateList() error = nil with failing storage")
+	}
+	// crash: the client was told the second update failed, it must not be
+	// replayed
+	wal.Close()
+
+	reopened, err := file.OpenWAL(walFile, file.SyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got, err := reopened.Replay(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != counter {
+		t.Errorf("Replay() = %v, want only [%v]", got, counter)
+	}
+}
+
+// uncommittedWAL keeps segments on Commit, as a crash right after the
+// backup is written would.
+type uncommittedWAL struct {
+	*file.WAL
+}
+
+func (uncommittedWAL) Commit(seq int) error { return nil }
+
+func TestAsyncController_Load_skipsCheckpointedWAL(t *testing.T) {
+	tests := []struct {
+		name        string
+		incremental bool
+	}{
+		{name: "full backup", incremental: false},
+		{name: "delta backup", incremental: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			backupFile := filepath.Join(dir, "metrics-db.json")
+			walFile := filepath.Join(dir, "metrics-db.wal")
+
+			newController := func() *AsyncController {
+				wal, err := file.OpenWAL(walFile, file.SyncAlways, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				backup := file.NewStorage(backupFile)
+				c := &AsyncController{
+					time:          time.Hour,
+					storage:       inmemory.NewStorage(),
+					backup:        backup,
+					wal:           uncommittedWAL{wal},
+					statusTracker: newStatusTracker(time.Hour),
+				}
+				if tt.incremental {
+					c.dirty = newDirtySet(backup)
+				}
+				return c
+			}
+
+			ctx := context.Background()
+			counter := models.MetricsWithValue{ID: "testCounter", MType: "counter", Delta: 2}
+
+			c := newController()
+			c.Update(ctx, counter)
+			if err := c.flush(); err != nil {
+				t.Fatal(err)
+			}
+			c.Update(ctx, counter)
+			if err := c.flush(); err != nil {
+				t.Fatal(err)
+			}
+			c.Update(ctx, counter)
+			// crash: segments of both flushes are left on disk
+
+			restarted := newController()
+			defer restarted.Close()
+			if err := restarted.Load(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := restarted.Get(ctx, models.MetricsWithValue{ID: "testCounter", MType: "counter"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Delta != 6 {
+				t.Errorf("Get() after restart = %d, want 6", got.Delta)
+			}
+		})
+	}
+}
+
+func TestNewStorageManager_walNeedsCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.ServerConfig{
+		StoreInterval: time.Hour,
+		WALFile:       filepath.Join(dir, "metrics-db.wal"),
+		WALSync:       string(file.SyncAlways),
+	}
+
+	if _, err := NewStorageManager(cfg); !errors.Is(err, ErrCheckpointUnsupported) {
+		t.Errorf("NewStorageManager() without backup error = %v, want %v", err, ErrCheckpointUnsupported)
+	}
+}
+
+func TestNewStorageManager_restoreFrom(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.ServerConfig{
+		FileStoragePath:  filepath.Join(dir, "metrics-db.json"),
+		StoreInterval:    time.Hour,
+		WALFile:          filepath.Join(dir, "metrics-db.wal"),
+		WALSync:          string(file.SyncAlways),
+		SnapshotKeepLast: 10,
+	}
+	ctx := context.Background()
+	counter := models.MetricsWithValue{ID: "testCounter", MType: "counter", Delta: 1}
+
+	sm, err := NewStorageManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.Update(ctx, counter)
+	sm.(*AsyncController).flush()
+	snapshots, err := sm.Snapshots()
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Snapshots() = %v, %v, want one snapshot", snapshots, err)
+	}
+
+	sm.UpdateList(ctx, []models.MetricsWithValue{counter, counter})
+	sm.(*AsyncController).flush()
+	sm.Update(ctx, counter)
+	sm.Close()
+
+	cfg.RestoreFrom = snapshots[0].Name
+	restored, err := NewStorageManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if err := restored.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := restored.Get(ctx, models.MetricsWithValue{ID: "testCounter", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Delta != 1 {
+		t.Errorf("Get() after restore from first snapshot = %d, want 1", got.Delta)
+	}
+
+	cfg.RestoreFrom = "missing"
+	if _, err := NewStorageManager(cfg); !errors.Is(err, file.ErrSnapshotNotFound) {
+		t.Errorf("NewStorageManager() error = %v, want %v", err, file.ErrSnapshotNotFound)
+	}
+}
+
+func TestAsyncController_nextRetryWait(t *testing.T) {
+	c := &AsyncController{
+		time:      time.Minute,
+		retryWait: 10 * time.Second,
+	}
+
+	tests := []struct {
+		name string
+		wait time.Duration
+		want time.Duration
+	}{
+		{
+			name: "first retry",
+			wait: 0,
+			want: 10 * time.Second,
+		},
+		{
+			name: "doubled",
+			wait: 20 * time.Second,
+			want: 40 * time.Second,
+		},
+		{
+			name: "capped by store interval",
+			wait: 40 * time.Second,
+			want: time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.nextRetryWait(tt.wait); got != tt.want {
+				t.Errorf("nextRetryWait(%v) = %v, want %v", tt.wait, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsyncController_Run_retriesFailedFlush(t *testing.T) {
+	backup := &flakyBackup{FileStorage: file.NewStorage(filepath.Join(t.TempDir(), "metrics-db.json"))}
+	backup.fail.Store(true)
+
+	c := &AsyncController{
+		time:          20 * time.Millisecond,
+		retryWait:     time.Millisecond,
+		storage:       inmemory.NewStorage(),
+		backup:        backup,
+		statusTracker: newStatusTracker(20 * time.Millisecond),
+	}
+	c.SetDegradeAfter(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(cond func(Status) bool) {
+		deadline := time.Now().Add(3 * time.Second)
+		for !cond(c.Status()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("status not reached, got %+v", c.Status())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitFor(func(s Status) bool { return s.ConsecutiveFailures >= 2 && s.Degraded })
+	if err := c.Update(context.Background(), models.MetricsWithValue{ID: "g", MType: "gauge", Value: 1}); !errors.Is(err, ErrDegraded) {
+		t.Errorf("Update() in degraded mode error = %v, want %v", err, ErrDegraded)
+	}
+
+	backup.fail.Store(false)
+	waitFor(func(s Status) bool { return s.ConsecutiveFailures == 0 && !s.LastFlush.IsZero() })
+	if c.Status().Degraded {
+		t.Errorf("Status().Degraded = true after recovery")
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+type CacheStats struct {
+	Hits           int64  `json:"hits"`
+	Misses         int64  `json:"misses"`
+	Pending        int    `json:"pending"`
+	LastFlushError string `json:"last_flush_error,omitempty"`
+}
+
+// CachedStorage serves reads from memory and keeps backing storage, which
+// is the source of truth, up to date. With zero window every update is
+// written through, otherwise updates are collected and written to backing
+// storage once per window.
+type CachedStorage struct {
+	cache   *inmemory.MemStorage
+	backing MainStorage
+	window  time.Duration
+
+	pending   *inmemory.MemStorage
+	flushErr  error
+	pendingMu sync.Mutex
+	flushMu   sync.Mutex
+	writeMu   sync.Mutex
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCachedStorage warms the cache with everything backing storage holds,
+// so a miss means the metric is unknown or written by another instance.
+func NewCachedStorage(ctx context.Context, backing MainStorage, window time.Duration) (*CachedStorage, error) {
+	s := &CachedStorage{
+		cache:   inmemory.NewStorage(),
+		backing: backing,
+		window:  window,
+		pending: inmemory.NewStorage(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	list, err := backing.GetList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cache.UpdateList(ctx, list); err != nil {
+		return nil, err
+	}
+
+	if window > 0 {
+		go s.run()
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+func (s *CachedStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	cached, err := s.cache.Get(ctx, metric)
+	if err == nil {
+		s.hits.Add(1)
+		return cached, nil
+	}
+	s.misses.Add(1)
+
+	// Not cached, since filling the cache here could race with updates
+	// of the same metric.
+	return s.backing.Get(ctx, metric)
+}
+
+func (s *CachedStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	return s.cache.GetList(ctx)
+}
+
+func (s *CachedStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	return s.UpdateList(ctx, []models.MetricsWithValue{metric})
+}
+
+func (s *CachedStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	if s.window == 0 {
+		// Concurrent updates have to reach the cache in the order they
+		// reached backing storage, or gauges set by them stay different.
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+
+		if err := s.backing.UpdateList(ctx, list); err != nil {
+			return err
+		}
+		// The update is committed, so a cancelled request must not keep it
+		// out of the cache.
+		return s.cache.UpdateList(withoutCancel(ctx), list)
+	}
+
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	if err := s.pending.UpdateList(ctx, list); err != nil {
+		return err
+	}
+	return s.cache.UpdateList(withoutCancel(ctx), list)
+}
+
+// detachedContext keeps values of the parent context but is never
+// cancelled, like context.WithoutCancel which needs Go 1.21.
+type detachedContext struct {
+	context.Context
+}
+
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (s *CachedStorage) Ping() error {
+	return s.backing.Ping()
+}
+
+// Close writes pending updates before closing backing storage.
+func (s *CachedStorage) Close() error {
+	var err error
+	if s.window > 0 {
+		close(s.stop)
+		<-s.done
+		err = s.flush(context.Background())
+	}
+	return errors.Join(err, s.backing.Close(), s.cache.Close())
+}
+
+func (s *CachedStorage) Stats() CacheStats {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	pending, _ := s.pending.GetList(context.Background())
+	stats := CacheStats{
+		Hits:    s.hits.Load(),
+		Misses:  s.misses.Load(),
+		Pending: len(pending),
+	}
+	if s.flushErr != nil {
+		stats.LastFlushError = s.flushErr.Error()
+	}
+	return stats
+}
+
+func (s *CachedStorage) SetRetryCount(attempts int) {
+	s.backing.SetRetryCount(attempts)
+}
+
+func (s *CachedStorage) SetRetryStartWaitTime(sleep time.Duration) {
+	s.backing.SetRetryStartWaitTime(sleep)
+}
+
+func (s *CachedStorage) SetRetryIncreaseWaitTime(delta time.Duration) {
+	s.backing.SetRetryIncreaseWaitTime(delta)
+}
+
+func (s *CachedStorage) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.flush(context.Background())
+		}
+	}
+}
+
+func (s *CachedStorage) flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.pendingMu.Lock()
+	list, _ := s.pending.GetList(ctx)
+	s.pending = inmemory.NewStorage()
+	s.pendingMu.Unlock()
+
+	if len(list) == 0 {
+		return nil
+	}
+
+	err := s.backing.UpdateList(ctx, list)
+
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	s.flushErr = err
+	if err != nil {
+		s.requeue(ctx, list)
+	}
+	return err
+}
+
+// requeue returns updates of a failed flush. Counter deltas add up with
+// the ones received meanwhile, gauges are kept only if not set again.
+func (s *CachedStorage) requeue(ctx context.Context, list []models.MetricsWithValue) {
+	for _, metric := range list {
+		if metric.MType == "gauge" {
+			if _, err := s.pending.Get(ctx, metric); err == nil {
+				continue
+			}
+		}
+		s.pending.Update(ctx, metric)
+	}
+}
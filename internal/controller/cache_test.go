@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+type backingStorage struct {
+	*inmemory.MemStorage
+	gets    atomic.Int64
+	fail    atomic.Bool
+	written func()
+}
+
+func newBackingStorage(list ...models.MetricsWithValue) *backingStorage {
+	b := &backingStorage{MemStorage: inmemory.NewStorage()}
+	b.MemStorage.UpdateList(context.Background(), list)
+	return b
+}
+
+func (b *backingStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	b.gets.Add(1)
+	return b.MemStorage.Get(ctx, metric)
+}
+
+func (b *backingStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	if b.fail.Load() {
+		return errors.New("database unavailable")
+	}
+	if err := b.MemStorage.UpdateList(ctx, list); err != nil {
+		return err
+	}
+	if b.written != nil {
+		b.written()
+	}
+	return nil
+}
+
+func (b *backingStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	return b.UpdateList(ctx, []models.MetricsWithValue{metric})
+}
+
+func TestCachedStorage_Get(t *testing.T) {
+	ctx := context.Background()
+	counter := models.MetricsWithValue{ID: "c", MType: "counter", Delta: 3}
+	backing := newBackingStorage(counter)
+
+	s, err := NewCachedStorage(ctx, backing, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	got, err := s.Get(ctx, models.MetricsWithValue{ID: "c", MType: "counter"})
+	if err != nil || got != counter {
+		t.Errorf("Get() = %v, %v, want %v", got, err, counter)
+	}
+	if _, err := s.Get(ctx, models.MetricsWithValue{ID: "unknown", MType: "gauge"}); err == nil {
+		t.Errorf("Get() of unknown metric error = nil")
+	}
+
+	want := CacheStats{Hits: 1, Misses: 1}
+	if stats := s.Stats(); stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+	if gets := backing.gets.Load(); gets != 1 {
+		t.Errorf("backing storage reads = %d, want 1", gets)
+	}
+}
+
+func TestCachedStorage_writeThrough(t *testing.T) {
+	ctx := context.Background()
+	backing := newBackingStorage()
+
+	s, err := NewCachedStorage(ctx, backing, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	metric := models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1}
+	if err := s.Update(ctx, metric); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := backing.MemStorage.Get(ctx, metric); got != metric {
+		t.Errorf("backing storage = %v, want %v", got, metric)
+	}
+
+	backing.fail.Store(true)
+	if err := s.Update(ctx, metric); err == nil {
+		t.Fatal("Update() error = nil with failing backing storage")
+	}
+	if got, _ := s.Get(ctx, metric); got != metric {
+		t.Errorf("cache after failed update = %v, want %v", got, metric)
+	}
+}
+
+func TestCachedStorage_cancelledAfterWrite(t *testing.T) {
+	backing := newBackingStorage()
+	s, err := NewCachedStorage(context.Background(), backing, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The request is cancelled right after backing storage committed it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backing.written = cancel
+
+	metric := models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1}
+	if err := s.Update(ctx, metric); err != nil {
+		t.Errorf("Update() error = %v", err)
+	}
+	if got, _ := s.Get(context.Background(), metric); got != metric {
+		t.Errorf("cache = %v, want %v", got, metric)
+	}
+}
+
+func TestCachedStorage_writeThroughOrder(t *testing.T) {
+	ctx := context.Background()
+	backing := newBackingStorage()
+
+	s, err := NewCachedStorage(ctx, backing, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The first update stalls after it is written to backing storage, until
+	// the second one is done or a while passes.
+	second := make(chan struct{})
+	var calls atomic.Int64
+	backing.written = func() {
+		if calls.Add(1) == 1 {
+			select {
+			case <-second:
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		s.Update(ctx, models.MetricsWithValue{ID: "g", MType: "gauge", Value: 1})
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Update(ctx, models.MetricsWithValue{ID: "g", MType: "gauge", Value: 2})
+	close(second)
+	<-first
+
+	cached, _ := s.Get(ctx, models.MetricsWithValue{ID: "g", MType: "gauge"})
+	stored, _ := backing.MemStorage.Get(ctx, models.MetricsWithValue{ID: "g", MType: "gauge"})
+	if cached != stored {
+		t.Errorf("cached %v, stored %v", cached, stored)
+	}
+}
+
+func TestCachedStorage_writeBehind(t *testing.T) {
+	ctx := context.Background()
+	backing := newBackingStorage(models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1})
+
+	s, err := NewCachedStorage(ctx, backing, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 2},
+		{ID: "g", MType: "gauge", Value: 1},
+	}
+	if err := s.UpdateList(ctx, updates); err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.Stats(); stats.Pending != 2 {
+		t.Errorf("Stats().Pending = %d, want 2", stats.Pending)
+	}
+
+	// Failed flush keeps the updates, newer gauge value wins.
+	backing.fail.Store(true)
+	if err := s.flush(ctx); err == nil {
+		t.Fatal("flush() error = nil with failing backing storage")
+	}
+	if err := s.UpdateList(ctx, []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 4},
+		{ID: "g", MType: "gauge", Value: 5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	backing.fail.Store(false)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 7},
+		{ID: "g", MType: "gauge", Value: 5},
+	}
+	got, _ := backing.MemStorage.GetList(ctx)
+	if !reflect.DeepEqual(sortList(got), want) {
+		t.Errorf("backing storage after Close() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+type LimitPolicy string
+
+const (
+	// LimitReject fails the whole update with ErrCardinalityLimit if any
+	// of its metrics is over a limit.
+	LimitReject LimitPolicy = "reject"
+	// LimitDrop stores metrics within limits, the rest are dropped and
+	// counted.
+	LimitDrop LimitPolicy = "drop"
+)
+
+var (
+	ErrCardinalityLimit   = errors.New("cardinality limit exceeded")
+	ErrUnknownLimitPolicy = errors.New("unknown cardinality limit policy")
+)
+
+// Limits bound the number of series and length of their names, zero
+// disables a limit. Series of the same name and different types count
+// separately.
+type Limits struct {
+	MaxSeries         int
+	MaxSeriesPerAgent int
+	MaxNameLength     int
+	Policy            LimitPolicy
+}
+
+func (l Limits) validate() error {
+	switch l.Policy {
+	case "", LimitReject, LimitDrop:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownLimitPolicy, l.Policy)
+}
+
+// Cardinality reports series with names starting with Prefix, grouped by
+// the prefix extended up to the next separator.
+type Cardinality struct {
+	Series            int            `json:"series"`
+	MaxSeries         int            `json:"max_series"`
+	MaxSeriesPerAgent int            `json:"max_series_per_agent"`
+	Prefix            string         `json:"prefix"`
+	Matched           int            `json:"matched"`
+	Prefixes          map[string]int `json:"prefixes"`
+	Agents            map[string]int `json:"agents"`
+	Dropped           int64          `json:"dropped"`
+	Rejected          int64          `json:"rejected"`
+}
+
+type agentKey struct{}
+
+// WithAgent tells the controller which agent makes the update, its series
+// are counted against MaxSeriesPerAgent.
+func WithAgent(ctx context.Context, agent string) context.Context {
+	return context.WithValue(ctx, agentKey{}, agent)
+}
+
+func agentFrom(ctx context.Context) string {
+	agent, _ := ctx.Value(agentKey{}).(string)
+	return agent
+}
+
+// cardinalityTracker knows every series of the main storage and the agent
+// that created it. Series already stored are read from the storage on the
+// first use after the storage is loaded or replaced, they have no agent.
+type cardinalityTracker struct {
+	limits     Limits
+	seeded     bool
+	generation int
+	series     map[metricKey]*trackedSeries
+	agents     map[string]int
+	dropped    int64
+	rejected   int64
+	mu         sync.Mutex
+}
+
+// trackedSeries counts updates that may add the series and are not done
+// yet. A series nobody has stored yet is forgotten once all of them fail,
+// so a failed update never drops a series another update stored.
+type trackedSeries struct {
+	agent   string
+	pending int
+	stored  bool
+}
+
+// claim is series an update may add, taken in generation of the tracker.
+// Claims taken before the tracker was seeded again are void.
+type claim struct {
+	generation int
+	keys       []metricKey
+}
+
+func (t *cardinalityTracker) SetLimits(limits Limits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits = limits
+}
+
+// reseed makes the tracker read series from the storage again, it is called
+// once the storage content is replaced by Load or Set.
+func (t *cardinalityTracker) reseed() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seeded = false
+}
+
+func (t *cardinalityTracker) seed(ctx context.Context, storage MainStorage) error {
+	if t.seeded {
+		return nil
+	}
+
+	list, err := storage.GetList(ctx)
+	if err != nil {
+		return fmt.Errorf("count series: %w", err)
+	}
+
+	t.series = make(map[metricKey]*trackedSeries, len(list))
+	t.agents = make(map[string]int)
+	for _, metric := range list {
+		t.series[metricKey{mtype: metric.MType, id: metric.ID}] = &trackedSeries{stored: true}
+	}
+	t.seeded = true
+	t.generation++
+	return nil
+}
+
+// admit returns metrics of list that may be stored and claim on series
+// the update may add. The claim has to be passed to confirm if the update
+// succeeds and to forget if it fails, so that series it failed to add
+// don't count against the limits.
+func (t *cardinalityTracker) admit(ctx context.Context, storage MainStorage, list []models.MetricsWithValue) ([]models.MetricsWithValue, claim, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.seed(ctx, storage); err != nil {
+		return nil, claim{}, err
+	}
+
+	agent := agentFrom(ctx)
+	accepted := make([]models.MetricsWithValue, 0, len(list))
+	pending := claim{generation: t.generation}
+	claimed := make(map[metricKey]bool)
+
+	for _, metric := range list {
+		// Storages ignore metrics of unknown types, they make no series.
+		if metric.MType != "counter" && metric.MType != "gauge" {
+			accepted = append(accepted, metric)
+			continue
+		}
+
+		err := t.check(metric, agent)
+		if err == nil {
+			key := metricKey{mtype: metric.MType, id: metric.ID}
+			series, ok := t.series[key]
+			if !ok {
+				series = &trackedSeries{agent: agent}
+				t.series[key] = series
+				if agent != "" {
+					t.agents[agent]++
+				}
+			}
+			if !series.stored && !claimed[key] {
+				series.pending++
+				pending.keys = append(pending.keys, key)
+				claimed[key] = true
+			}
+			accepted = append(accepted, metric)
+			continue
+		}
+
+		if t.limits.Policy != LimitDrop {
+			t.rejected++
+			t.release(pending, false)
+			return nil, claim{}, err
+		}
+		t.dropped++
+	}
+	return accepted, pending, nil
+}
+
+func (t *cardinalityTracker) check(metric models.MetricsWithValue, agent string) error {
+	if t.limits.MaxNameLength > 0 && len(metric.ID) > t.limits.MaxNameLength {
+		return fmt.Errorf("%w: %s name of %d bytes is longer than %d", ErrCardinalityLimit, metric.MType, len(metric.ID), t.limits.MaxNameLength)
+	}
+
+	if _, ok := t.series[metricKey{mtype: metric.MType, id: metric.ID}]; ok {
+		return nil
+	}
+	if t.limits.MaxSeries > 0 && len(t.series) >= t.limits.MaxSeries {
+		return fmt.Errorf("%w: %d series stored, new %s/%s is over limit", ErrCardinalityLimit, len(t.series), metric.MType, metric.ID)
+	}
+	if agent != "" && t.limits.MaxSeriesPerAgent > 0 && t.agents[agent] >= t.limits.MaxSeriesPerAgent {
+		return fmt.Errorf("%w: agent %s created %d series, new %s/%s is over limit", ErrCardinalityLimit, agent, t.agents[agent], metric.MType, metric.ID)
+	}
+	return nil
+}
+
+// release ends update holding c. Claims taken before the tracker was
+// seeded again are skipped, the storage has told whether they were stored.
+func (t *cardinalityTracker) release(c claim, stored bool) {
+	if c.generation != t.generation {
+		return
+	}
+
+	for _, key := range c.keys {
+		series := t.series[key]
+		series.pending--
+		series.stored = series.stored || stored
+		if series.stored || series.pending > 0 {
+			continue
+		}
+
+		if series.agent != "" {
+			t.agents[series.agent]--
+			if t.agents[series.agent] == 0 {
+				delete(t.agents, series.agent)
+			}
+		}
+		delete(t.series, key)
+	}
+}
+
+func (t *cardinalityTracker) confirm(c claim) {
+	if len(c.keys) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.release(c, true)
+}
+
+func (t *cardinalityTracker) forget(c claim) {
+	if len(c.keys) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.release(c, false)
+}
+
+func (t *cardinalityTracker) report(ctx context.Context, storage MainStorage, prefix string) (Cardinality, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.seed(ctx, storage); err != nil {
+		return Cardinality{}, err
+	}
+
+	c := Cardinality{
+		Series:            len(t.series),
+		MaxSeries:         t.limits.MaxSeries,
+		MaxSeriesPerAgent: t.limits.MaxSeriesPerAgent,
+		Prefix:            prefix,
+		Prefixes:          make(map[string]int),
+		Agents:            make(map[string]int, len(t.agents)),
+		Dropped:           t.dropped,
+		Rejected:          t.rejected,
+	}
+	for key := range t.series {
+		if !strings.HasPrefix(key.id, prefix) {
+			continue
+		}
+		c.Matched++
+		c.Prefixes[prefix+nextSegment(key.id[len(prefix):])]++
+	}
+	for agent, series := range t.agents {
+		c.Agents[agent] = series
+	}
+	return c, nil
+}
+
+const separators = "_.:/-"
+
+// nextSegment returns rest of name up to a separator, leading separators
+// included, so that http_ groups http_requests_total as http_requests.
+func nextSegment(rest string) string {
+	start := 0
+	for start < len(rest) && strings.IndexByte(separators, rest[start]) >= 0 {
+		start++
+	}
+	if end := strings.IndexAny(rest[start:], separators); end >= 0 {
+		return rest[:start+end]
+	}
+	return rest
+}
@@ -0,0 +1,341 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+type limitedController interface {
+	MainStorage
+	SetLimits(limits Limits)
+	Cardinality(ctx context.Context, prefix string) (Cardinality, error)
+}
+
+func TestController_limits(t *testing.T) {
+	type step struct {
+		agent   string
+		list    []models.MetricsWithValue
+		wantErr error
+	}
+
+	tests := []struct {
+		name         string
+		limits       Limits
+		stored       []models.MetricsWithValue
+		steps        []step
+		want         []models.MetricsWithValue
+		wantSeries   int
+		wantDropped  int64
+		wantRejected int64
+	}{
+		{
+			name:   "batch over total limit is rejected",
+			limits: Limits{MaxSeries: 2},
+			stored: []models.MetricsWithValue{{ID: "a", MType: "counter", Delta: 1}},
+			steps: []step{
+				{
+					list: []models.MetricsWithValue{
+						{ID: "b", MType: "gauge", Value: 1},
+						{ID: "c", MType: "gauge", Value: 1},
+					},
+					wantErr: ErrCardinalityLimit,
+				},
+				{
+					list: []models.MetricsWithValue{
+						{ID: "a", MType: "counter", Delta: 1},
+						{ID: "c", MType: "gauge", Value: 2},
+					},
+				},
+			},
+			want: []models.MetricsWithValue{
+				{ID: "a", MType: "counter", Delta: 2},
+				{ID: "c", MType: "gauge", Value: 2},
+			},
+			wantSeries:   2,
+			wantRejected: 1,
+		},
+		{
+			name:   "per agent limit",
+			limits: Limits{MaxSeriesPerAgent: 1},
+			steps: []step{
+				{agent: "ip:10.0.0.1", list: []models.MetricsWithValue{{ID: "x", MType: "gauge", Value: 1}}},
+				{agent: "ip:10.0.0.1", list: []models.MetricsWithValue{{ID: "y", MType: "gauge", Value: 1}}, wantErr: ErrCardinalityLimit},
+				{agent: "ip:10.0.0.1", list: []models.MetricsWithValue{{ID: "x", MType: "gauge", Value: 2}}},
+				{agent: "ip:10.0.0.2", list: []models.MetricsWithValue{{ID: "y", MType: "gauge", Value: 3}}},
+			},
+			want: []models.MetricsWithValue{
+				{ID: "x", MType: "gauge", Value: 2},
+				{ID: "y", MType: "gauge", Value: 3},
+			},
+			wantSeries:   2,
+			wantRejected: 1,
+		},
+		{
+			name:   "long name",
+			limits: Limits{MaxNameLength: 3},
+			steps: []step{
+				{list: []models.MetricsWithValue{{ID: "abcd", MType: "counter", Delta: 1}}, wantErr: ErrCardinalityLimit},
+				{list: []models.MetricsWithValue{{ID: "abc", MType: "counter", Delta: 1}}},
+			},
+			want:         []models.MetricsWithValue{{ID: "abc", MType: "counter", Delta: 1}},
+			wantSeries:   1,
+			wantRejected: 1,
+		},
+		{
+			name:   "metrics over limit are dropped",
+			limits: Limits{MaxSeries: 2, MaxNameLength: 8, Policy: LimitDrop},
+			steps: []step{
+				{
+					list: []models.MetricsWithValue{
+						{ID: "a", MType: "counter", Delta: 1},
+						{ID: "b", MType: "gauge", Value: 1},
+						{ID: "c", MType: "gauge", Value: 1},
+						{ID: "a", MType: "counter", Delta: 1},
+						{ID: "very long name", MType: "counter", Delta: 1},
+					},
+				},
+				{list: []models.MetricsWithValue{{ID: "d", MType: "gauge", Value: 1}}},
+			},
+			want: []models.MetricsWithValue{
+				{ID: "a", MType: "counter", Delta: 2},
+				{ID: "b", MType: "gauge", Value: 1},
+			},
+			wantSeries:  2,
+			wantDropped: 3,
+		},
+	}
+
+	controllers := []struct {
+		name string
+		new  func(storage MainStorage) limitedController
+	}{
+		{
+			name: "sync",
+			new: func(storage MainStorage) limitedController {
+				return &SyncController{
+					storage:       storage,
+					backup:        nopBackup{},
+					dirty:         newDirtySet(nopBackup{}),
+					statusTracker: newStatusTracker(0),
+				}
+			},
+		},
+		{
+			name: "async",
+			new: func(storage MainStorage) limitedController {
+				return &AsyncController{
+					time:          time.Minute,
+					storage:       storage,
+					backup:        nopBackup{},
+					dirty:         newDirtySet(nopBackup{}),
+					statusTracker: newStatusTracker(time.Minute),
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, ctrl := range controllers {
+			t.Run(ctrl.name+" "+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+
+				storage := inmemory.NewStorage()
+				storage.UpdateList(ctx, tt.stored)
+
+				c := ctrl.new(storage)
+				c.SetLimits(tt.limits)
+
+				for i, step := range tt.steps {
+					err := c.UpdateList(WithAgent(ctx, step.agent), step.list)
+					if !errors.Is(err, step.wantErr) {
+						t.Fatalf("step %d: UpdateList() error = %v, want %v", i, err, step.wantErr)
+					}
+				}
+
+				got, err := storage.GetList(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sortList(got); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("stored %v, want %v", got, tt.want)
+				}
+
+				report, err := c.Cardinality(ctx, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Series != tt.wantSeries || report.Dropped != tt.wantDropped || report.Rejected != tt.wantRejected {
+					t.Errorf("Cardinality() = %d series, %d dropped, %d rejected, want %d, %d, %d",
+						report.Series, report.Dropped, report.Rejected, tt.wantSeries, tt.wantDropped, tt.wantRejected)
+				}
+			})
+		}
+	}
+}
+
+func TestController_Cardinality(t *testing.T) {
+	ctx := context.Background()
+
+	storage := inmemory.NewStorage()
+	storage.UpdateList(ctx, []models.MetricsWithValue{
+		{ID: "Alloc", MType: "gauge"},
+		{ID: "http_requests_total", MType: "counter"},
+	})
+
+	c := &SyncController{
+		storage:       storage,
+		backup:        nopBackup{},
+		dirty:         newDirtySet(nopBackup{}),
+		statusTracker: newStatusTracker(0),
+	}
+
+	err := c.UpdateList(WithAgent(ctx, "token:agent"), []models.MetricsWithValue{
+		{ID: "http_requests_failed", MType: "counter"},
+		{ID: "http_requests_failed", MType: "gauge"},
+		{ID: "http_latency", MType: "gauge"},
+		{ID: "Alloc", MType: "gauge"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix       string
+		wantMatched  int
+		wantPrefixes map[string]int
+	}{
+		{
+			prefix:       "",
+			wantMatched:  5,
+			wantPrefixes: map[string]int{"Alloc": 1, "http": 4},
+		},
+		{
+			prefix:       "http_",
+			wantMatched:  4,
+			wantPrefixes: map[string]int{"http_requests": 3, "http_latency": 1},
+		},
+		{
+			prefix:       "http_requests",
+			wantMatched:  3,
+			wantPrefixes: map[string]int{"http_requests_total": 1, "http_requests_failed": 2},
+		},
+		{
+			prefix:       "missing",
+			wantPrefixes: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			report, err := c.Cardinality(ctx, tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Series != 5 || report.Matched != tt.wantMatched {
+				t.Errorf("Cardinality() = %d series, %d matched, want 5, %d", report.Series, report.Matched, tt.wantMatched)
+			}
+			if !reflect.DeepEqual(report.Prefixes, tt.wantPrefixes) {
+				t.Errorf("Cardinality().Prefixes = %v, want %v", report.Prefixes, tt.wantPrefixes)
+			}
+			// Series restored from storage have no agent.
+			if want := map[string]int{"token:agent": 3}; !reflect.DeepEqual(report.Agents, want) {
+				t.Errorf("Cardinality().Agents = %v, want %v", report.Agents, want)
+			}
+		})
+	}
+}
+
+func TestCardinalityTracker_claims(t *testing.T) {
+	key := metricKey{mtype: "gauge", id: "new"}
+	list := []models.MetricsWithValue{{ID: "new", MType: "gauge", Value: 1}}
+
+	tests := []struct {
+		name       string
+		first      bool
+		second     bool
+		wantSeries int
+	}{
+		{name: "first fails, second stores", first: false, second: true, wantSeries: 1},
+		{name: "first stores, second fails", first: true, second: false, wantSeries: 1},
+		{name: "both fail", wantSeries: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			storage := inmemory.NewStorage()
+			tracker := cardinalityTracker{}
+
+			// Both updates are admitted before either is done, as with two
+			// concurrent requests creating the same series.
+			_, first, err := tracker.admit(WithAgent(ctx, "a"), storage, list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, second, err := tracker.admit(WithAgent(ctx, "b"), storage, list)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, done := range []struct {
+				claim  claim
+				stored bool
+			}{{first, tt.first}, {second, tt.second}} {
+				if done.stored {
+					tracker.confirm(done.claim)
+				} else {
+					tracker.forget(done.claim)
+				}
+			}
+
+			report, err := tracker.report(ctx, storage, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Series != tt.wantSeries {
+				t.Errorf("Cardinality() = %d series, want %d", report.Series, tt.wantSeries)
+			}
+			if _, ok := tracker.series[key]; ok != (tt.wantSeries == 1) {
+				t.Errorf("series %v tracked = %t, want %t", key, ok, tt.wantSeries == 1)
+			}
+		})
+	}
+}
+
+func TestController_CardinalityAfterLoad(t *testing.T) {
+	ctx := context.Background()
+
+	backup := inmemory.NewStorage()
+	backup.UpdateList(ctx, []models.MetricsWithValue{
+		{ID: "a", MType: "counter", Delta: 1},
+		{ID: "b", MType: "gauge", Value: 1},
+	})
+
+	c := &SyncController{
+		storage:       inmemory.NewStorage(),
+		backup:        mainBackup{backup},
+		dirty:         newDirtySet(nopBackup{}),
+		statusTracker: newStatusTracker(0),
+	}
+
+	// Cardinality seeds the tracker from storage still empty.
+	if report, err := c.Cardinality(ctx, ""); err != nil || report.Series != 0 {
+		t.Fatalf("Cardinality() before Load() = %d series, %v, want 0", report.Series, err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := c.Cardinality(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Series != 2 {
+		t.Errorf("Cardinality() after Load() = %d series, want 2 restored ones", report.Series)
+	}
+}
@@ -1,39 +1,217 @@
 package controller
 
 import (
+	"context"
+	"fmt"
 	"time"
 
+	"go.uber.org/zap"
+
 	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
 	"github.com/h3ll0kitt1/observability/internal/storage/file"
 	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+	"github.com/h3ll0kitt1/observability/internal/storage/sql"
 )
 
 type StorageManager interface {
 	Load() error
-	Run()
+	Run(ctx context.Context) error
 	Set(MainStorage)
+	Status() Status
+	Snapshots() ([]models.Snapshot, error)
+	Cardinality(ctx context.Context, prefix string) (Cardinality, error)
+	CacheStats() (CacheStats, bool)
+	PoolStats() (PoolStats, bool)
 
 	SetRetryCount(attempts int)
 	SetRetryStartWaitTime(sleep time.Duration)
 	SetRetryIncreaseWaitTime(delta time.Duration)
+	SetLogger(logger *zap.SugaredLogger)
+	SetDegradeAfter(failures int)
 
 	MainStorage
 }
 
-func NewStorageManager(cfg *config.ServerConfig) StorageManager {
-	s := inmemory.NewStorage()
-	b := file.NewStorage(cfg.FileStoragePath)
+func NewStorageManager(cfg *config.ServerConfig) (StorageManager, error) {
+	limits := Limits{
+		MaxSeries:         cfg.MaxSeries,
+		MaxSeriesPerAgent: cfg.MaxSeriesPerAgent,
+		MaxNameLength:     cfg.MaxNameLength,
+		Policy:            LimitPolicy(cfg.CardinalityPolicy),
+	}
+	if err := limits.validate(); err != nil {
+		return nil, err
+	}
+
+	s, err := openMain(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("main storage: %w", err)
+	}
+
+	b, err := openBackup(cfg)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("backup storage: %w", err)
+	}
+
+	var restore BackupStorage
+	if cfg.RestoreFrom != "" {
+		restore, err = openSnapshot(b, cfg.RestoreFrom)
+		if err != nil {
+			s.Close()
+			b.Close()
+			return nil, fmt.Errorf("restore from %s: %w", cfg.RestoreFrom, err)
+		}
+	}
 
 	if cfg.StoreInterval == 0 {
-		return &SyncController{
-			storage: s,
-			backup:  b,
+		c := &SyncController{
+			storage:       s,
+			backup:        b,
+			restore:       restore,
+			dirty:         newDirtySet(b),
+			statusTracker: newStatusTracker(0),
+		}
+		c.SetDegradeAfter(cfg.DegradeAfter)
+		c.SetLimits(limits)
+		return c, nil
+	}
+
+	c := &AsyncController{
+		time:          cfg.StoreInterval,
+		storage:       s,
+		backup:        b,
+		restore:       restore,
+		dirty:         newDirtySet(b),
+		statusTracker: newStatusTracker(cfg.StoreInterval),
+	}
+	c.SetDegradeAfter(cfg.DegradeAfter)
+	c.SetLimits(limits)
+
+	if cfg.WALFile != "" {
+		if _, ok := b.(CheckpointBackup); !ok {
+			s.Close()
+			b.Close()
+			return nil, ErrCheckpointUnsupported
+		}
+		wal, err := file.OpenWAL(cfg.WALFile, file.SyncPolicy(cfg.WALSync), cfg.WALSyncInterval)
+		if err != nil {
+			s.Close()
+			b.Close()
+			return nil, err
 		}
+		c.wal = wal
 	}
+	return c, nil
+}
 
-	return &AsyncController{
-		time:    cfg.StoreInterval,
-		storage: s,
-		backup:  b,
+// openMain opens storage given by -main-storage, without it SQL database
+// from -d is used if set and memory otherwise.
+func openMain(cfg *config.ServerConfig) (MainStorage, error) {
+	var (
+		s   MainStorage
+		err error
+	)
+	switch {
+	case cfg.MainStorage != "":
+		s, err = OpenMainStorage(cfg.MainStorage, cfg)
+	case cfg.Database != "":
+		s, err = openDatabase(cfg.Database, cfg)
+	default:
+		s = newMemoryStorage(cfg.MemoryShards)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if inMemory(s) || !cfg.DBCache {
+		return s, nil
+	}
+	cache, err := NewCachedStorage(context.Background(), s, cfg.DBCacheWindow)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return cache, nil
+}
+
+// openBackup opens storage given by -backup-storage, without it file from
+// -f is used if set and backup is disabled otherwise.
+func openBackup(cfg *config.ServerConfig) (BackupStorage, error) {
+	switch {
+	case cfg.BackupStorage != "":
+		return OpenBackupStorage(cfg.BackupStorage, cfg)
+	case cfg.FileStoragePath != "":
+		return newFileStorage(cfg.FileStoragePath, cfg)
+	}
+	return nopBackup{}, nil
+}
+
+func openSnapshot(backup BackupStorage, name string) (BackupStorage, error) {
+	fs, ok := backup.(*file.FileStorage)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+	return fs.OpenSnapshot(name)
+}
+
+func inMemory(s MainStorage) bool {
+	switch s.(type) {
+	case *inmemory.MemStorage, *inmemory.ShardedStorage:
+		return true
+	}
+	return false
+}
+
+// setStorageLogger passes logger to main storage doing background work of
+// its own, like sql history maintenance.
+func setStorageLogger(storage MainStorage, logger *zap.SugaredLogger) {
+	if cache, ok := storage.(*CachedStorage); ok {
+		storage = cache.backing
+	}
+	if s, ok := storage.(interface{ SetLogger(*zap.SugaredLogger) }); ok {
+		s.SetLogger(logger)
+	}
+}
+
+func cacheStats(storage MainStorage) (CacheStats, bool) {
+	cache, ok := storage.(*CachedStorage)
+	if !ok {
+		return CacheStats{}, false
+	}
+	return cache.Stats(), true
+}
+
+// PoolStats is the state of postgres connection pool as /readyz reports it.
+type PoolStats struct {
+	AcquiredConns          int32   `json:"acquired_conns"`
+	IdleConns              int32   `json:"idle_conns"`
+	TotalConns             int32   `json:"total_conns"`
+	MaxConns               int32   `json:"max_conns"`
+	AcquireCount           int64   `json:"acquire_count"`
+	EmptyAcquireCount      int64   `json:"empty_acquire_count"`
+	CanceledAcquireCount   int64   `json:"canceled_acquire_count"`
+	AcquireDurationSeconds float64 `json:"acquire_duration_seconds"`
+}
+
+func poolStats(storage MainStorage) (PoolStats, bool) {
+	if cache, ok := storage.(*CachedStorage); ok {
+		storage = cache.backing
+	}
+	pool, ok := storage.(*sql.PoolStorage)
+	if !ok {
+		return PoolStats{}, false
 	}
+	stats := pool.Stats()
+	return PoolStats{
+		AcquiredConns:          stats.AcquiredConns,
+		IdleConns:              stats.IdleConns,
+		TotalConns:             stats.TotalConns,
+		MaxConns:               stats.MaxConns,
+		AcquireCount:           stats.AcquireCount,
+		EmptyAcquireCount:      stats.EmptyAcquireCount,
+		CanceledAcquireCount:   stats.CanceledAcquireCount,
+		AcquireDurationSeconds: stats.AcquireDurationSeconds,
+	}, true
 }
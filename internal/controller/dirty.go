@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+type metricKey struct {
+	mtype string
+	id    string
+}
+
+// dirtySet keeps series changed since the last successful flush. Until the
+// first full flush succeeds the backup may hold series the main storage
+// doesn't know about, so only then flushes become incremental.
+type dirtySet struct {
+	incremental bool
+	clean       bool
+	keys        map[metricKey]struct{}
+	mu          sync.Mutex
+}
+
+func newDirtySet(backup BackupStorage) dirtySet {
+	_, ok := backup.(IncrementalBackup)
+	return dirtySet{
+		incremental: ok,
+		keys:        make(map[metricKey]struct{}),
+	}
+}
+
+func (d *dirtySet) mark(list ...models.MetricsWithValue) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.keys == nil {
+		d.keys = make(map[metricKey]struct{})
+	}
+	for _, metric := range list {
+		d.keys[metricKey{mtype: metric.MType, id: metric.ID}] = struct{}{}
+	}
+}
+
+// take hands out the current set and reports whether writing only these
+// series is enough to bring the backup up to date.
+func (d *dirtySet) take() (map[metricKey]struct{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys := d.keys
+	d.keys = make(map[metricKey]struct{})
+	return keys, d.incremental && d.clean
+}
+
+// done returns keys of a failed flush to the set, so they are retried with
+// the next one.
+func (d *dirtySet) done(keys map[metricKey]struct{}, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		d.clean = true
+		return
+	}
+	for key := range keys {
+		d.keys[key] = struct{}{}
+	}
+}
+
+func changedList(ctx context.Context, storage MainStorage, keys map[metricKey]struct{}) ([]models.MetricsWithValue, error) {
+	list := make([]models.MetricsWithValue, 0, len(keys))
+	for key := range keys {
+		metric, err := storage.Get(ctx, models.MetricsWithValue{ID: key.id, MType: key.mtype})
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, metric)
+	}
+	return list, nil
+}
+
+func backupList(ctx context.Context, storage MainStorage, keys map[metricKey]struct{}, incremental bool) ([]models.MetricsWithValue, error) {
+	if incremental {
+		return changedList(ctx, storage, keys)
+	}
+	return storage.GetList(ctx)
+}
+
+func storeBackup(ctx context.Context, backup BackupStorage, list []models.MetricsWithValue, incremental bool) error {
+	if incremental {
+		return backup.(IncrementalBackup).UpdateChanged(ctx, list)
+	}
+	return backup.UpdateList(ctx, list)
+}
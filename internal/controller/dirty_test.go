@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/file"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+// fullBackup hides UpdateChanged of the wrapped storage.
+type fullBackup struct {
+	BackupStorage
+}
+
+type flakyBackup struct {
+	*file.FileStorage
+	fail atomic.Bool
+}
+
+func (b *flakyBackup) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	if b.fail.Load() {
+		return errors.New("backup unavailable")
+	}
+	return b.FileStorage.UpdateList(ctx, list)
+}
+
+func (b *flakyBackup) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	if b.fail.Load() {
+		return errors.New("backup unavailable")
+	}
+	return b.FileStorage.UpdateChanged(ctx, list)
+}
+
+func sortList(list []models.MetricsWithValue) []models.MetricsWithValue {
+	sort.Slice(list, func(i, j int) bool { return list[i].MType+list[i].ID < list[j].MType+list[j].ID })
+	return list
+}
+
+func TestSyncController_incrementalFlush(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+	backup := &flakyBackup{FileStorage: file.NewStorage(filename)}
+
+	c := &SyncController{
+		storage:       inmemory.NewStorage(),
+		backup:        backup,
+		dirty:         newDirtySet(backup),
+		statusTracker: newStatusTracker(0),
+	}
+
+	updates := []struct {
+		metric    models.MetricsWithValue
+		fail      bool
+		wantDelta bool
+	}{
+		{
+			metric: models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1},
+		},
+		{
+			metric:    models.MetricsWithValue{ID: "g", MType: "gauge", Value: 1},
+			wantDelta: true,
+		},
+		{
+			metric:    models.MetricsWithValue{ID: "c", MType: "counter", Delta: 2},
+			fail:      true,
+			wantDelta: true,
+		},
+		{
+			metric:    models.MetricsWithValue{ID: "g", MType: "gauge", Value: 3},
+			wantDelta: true,
+		},
+	}
+
+	for _, u := range updates {
+		backup.fail.Store(u.fail)
+		err := c.Update(ctx, u.metric)
+		if gotErr := err != nil; gotErr != u.fail {
+			t.Fatalf("Update(%v) error = %v, want error %v", u.metric, err, u.fail)
+		}
+
+		_, err = os.Stat(filename + ".delta")
+		if gotDelta := err == nil; gotDelta != u.wantDelta {
+			t.Errorf("after Update(%v) delta file exists = %v, want %v", u.metric, gotDelta, u.wantDelta)
+		}
+	}
+
+	// The counter change from the failed flush is written with the next one.
+	want := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 3},
+		{ID: "g", MType: "gauge", Value: 3},
+	}
+	got, err := file.NewStorage(filename).GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sortList(got), want) {
+		t.Errorf("backup = %v, want %v", got, want)
+	}
+}
+
+const benchSeries = 10000
+
+func benchStorage(b *testing.B) MainStorage {
+	s := inmemory.NewStorage()
+	list := make([]models.MetricsWithValue, 0, benchSeries)
+	for i := 0; i < benchSeries; i++ {
+		list = append(list, models.MetricsWithValue{ID: "metric" + strconv.Itoa(i), MType: "gauge", Value: float64(i)})
+	}
+	if err := s.UpdateList(context.Background(), list); err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+func benchBackups(b *testing.B) map[string]func() BackupStorage {
+	return map[string]func() BackupStorage{
+		"full": func() BackupStorage {
+			return fullBackup{file.NewStorage(filepath.Join(b.TempDir(), "metrics-db.json"))}
+		},
+		"incremental": func() BackupStorage {
+			return file.NewStorage(filepath.Join(b.TempDir(), "metrics-db.json"))
+		},
+	}
+}
+
+func BenchmarkSyncController_Update(b *testing.B) {
+	ctx := context.Background()
+
+	for name, newBackup := range benchBackups(b) {
+		b.Run(name, func(b *testing.B) {
+			backup := newBackup()
+			c := &SyncController{
+				storage:       benchStorage(b),
+				backup:        backup,
+				dirty:         newDirtySet(backup),
+				statusTracker: newStatusTracker(0),
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				metric := models.MetricsWithValue{ID: "metric" + strconv.Itoa(i%benchSeries), MType: "gauge", Value: float64(i)}
+				if err := c.Update(ctx, metric); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAsyncController_flush(b *testing.B) {
+	ctx := context.Background()
+
+	for name, newBackup := range benchBackups(b) {
+		b.Run(name, func(b *testing.B) {
+			backup := newBackup()
+			c := &AsyncController{
+				time:          time.Hour,
+				storage:       benchStorage(b),
+				backup:        backup,
+				dirty:         newDirtySet(backup),
+				statusTracker: newStatusTracker(time.Hour),
+			}
+			if err := c.flush(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// A hundred of series change between two flushes.
+				for j := 0; j < 100; j++ {
+					metric := models.MetricsWithValue{ID: "metric" + strconv.Itoa((i*100+j)%benchSeries), MType: "gauge", Value: float64(i)}
+					if err := c.Update(ctx, metric); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := c.flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+type MergePolicy string
+
+const (
+	// PolicyOverwrite replaces values in destination with source values.
+	PolicyOverwrite MergePolicy = "overwrite"
+	// PolicyMerge adds source counters to destination ones, gauges are
+	// taken from source.
+	PolicyMerge MergePolicy = "merge"
+)
+
+var (
+	ErrUnknownPolicy    = errors.New("unknown merge policy")
+	ErrVerifyMismatched = errors.New("destination doesn't match expected values")
+)
+
+type MigrateOptions struct {
+	Policy    MergePolicy
+	DryRun    bool
+	BatchSize int
+	Progress  io.Writer
+}
+
+type MigrateResult struct {
+	Read       int
+	Created    int
+	Updated    int
+	Unchanged  int
+	Written    int
+	Mismatched []string
+}
+
+// Migrate copies metrics from src to dst. Main storages used as
+// destination are written in batches, other backups such as files are
+// rewritten at once with destination content merged in.
+func Migrate(ctx context.Context, src BackupStorage, dst BackupStorage, opts MigrateOptions) (MigrateResult, error) {
+	var result MigrateResult
+
+	if opts.Policy != PolicyOverwrite && opts.Policy != PolicyMerge {
+		return result, ErrUnknownPolicy
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.Progress == nil {
+		opts.Progress = io.Discard
+	}
+
+	source, err := src.GetList(ctx)
+	if err != nil {
+		return result, fmt.Errorf("read source: %w", err)
+	}
+	result.Read = len(source)
+	fmt.Fprintf(opts.Progress, "read %d metrics from source\n", len(source))
+
+	existing, err := dst.GetList(ctx)
+	if err != nil {
+		return result, fmt.Errorf("read destination: %w", err)
+	}
+	fmt.Fprintf(opts.Progress, "read %d metrics from destination\n", len(existing))
+
+	index := make(map[metricKey]int, len(existing))
+	for i, metric := range existing {
+		index[metricKey{mtype: metric.MType, id: metric.ID}] = i
+	}
+
+	changed := make([]models.MetricsWithValue, 0, len(source))
+	for _, metric := range source {
+		key := metricKey{mtype: metric.MType, id: metric.ID}
+		i, ok := index[key]
+		if !ok {
+			result.Created++
+			index[key] = len(existing)
+			existing = append(existing, metric)
+			changed = append(changed, metric)
+			continue
+		}
+
+		if opts.Policy == PolicyMerge && metric.MType == "counter" {
+			metric.Delta += existing[i].Delta
+		}
+		if existing[i] == metric {
+			result.Unchanged++
+			continue
+		}
+		result.Updated++
+		existing[i] = metric
+		changed = append(changed, metric)
+	}
+	fmt.Fprintf(opts.Progress, "%d to create, %d to update, %d unchanged\n", result.Created, result.Updated, result.Unchanged)
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	// Only written values are verified: destination may be in use and
+	// its other metrics may change meanwhile.
+	var written []models.MetricsWithValue
+	if _, ok := dst.(mainBackup); ok {
+		for start := 0; start < len(changed); start += opts.BatchSize {
+			end := start + opts.BatchSize
+			if end > len(changed) {
+				end = len(changed)
+			}
+			if err := dst.UpdateList(ctx, changed[start:end]); err != nil {
+				return result, fmt.Errorf("write destination: %w", err)
+			}
+			result.Written = end
+			written = changed[:end]
+			fmt.Fprintf(opts.Progress, "written %d/%d\n", end, len(changed))
+		}
+	} else if len(changed) > 0 {
+		if err := dst.UpdateList(ctx, existing); err != nil {
+			return result, fmt.Errorf("write destination: %w", err)
+		}
+		result.Written = len(changed)
+		written = existing
+		fmt.Fprintf(opts.Progress, "written %d/%d\n", len(changed), len(changed))
+	}
+
+	result.Mismatched, err = verify(ctx, dst, written)
+	if err != nil {
+		return result, fmt.Errorf("verify destination: %w", err)
+	}
+	if len(result.Mismatched) > 0 {
+		return result, ErrVerifyMismatched
+	}
+	fmt.Fprintf(opts.Progress, "verified %d metrics\n", len(written))
+	return result, nil
+}
+
+func verify(ctx context.Context, dst BackupStorage, want []models.MetricsWithValue) ([]string, error) {
+	got, err := dst.GetList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[metricKey]models.MetricsWithValue, len(got))
+	for _, metric := range got {
+		stored[metricKey{mtype: metric.MType, id: metric.ID}] = metric
+	}
+
+	mismatched := make([]string, 0)
+	for _, metric := range want {
+		if s, ok := stored[metricKey{mtype: metric.MType, id: metric.ID}]; !ok || s != metric {
+			mismatched = append(mismatched, metric.MType+"/"+metric.ID)
+		}
+	}
+	return mismatched, nil
+}
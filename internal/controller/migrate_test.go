@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/file"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+// lossyBackup accepts writes without storing them.
+type lossyBackup struct {
+	nopBackup
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	source := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 5},
+		{ID: "g", MType: "gauge", Value: 2},
+		{ID: "new", MType: "gauge", Value: 1},
+	}
+	existing := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 3},
+		{ID: "g", MType: "gauge", Value: 2},
+		{ID: "old", MType: "counter", Delta: 1},
+	}
+
+	newFile := func(t *testing.T) BackupStorage {
+		fs := file.NewStorage(filepath.Join(t.TempDir(), "metrics-db.json"))
+		if err := fs.UpdateList(ctx, existing); err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+	newMemory := func(t *testing.T) BackupStorage {
+		ms := inmemory.NewStorage()
+		if err := ms.UpdateList(ctx, existing); err != nil {
+			t.Fatal(err)
+		}
+		return mainBackup{ms}
+	}
+
+	tests := []struct {
+		name       string
+		dst        func(t *testing.T) BackupStorage
+		opts       MigrateOptions
+		wantResult MigrateResult
+		want       []models.MetricsWithValue
+	}{
+		{
+			name:       "overwrite file",
+			dst:        newFile,
+			opts:       MigrateOptions{Policy: PolicyOverwrite},
+			wantResult: MigrateResult{Read: 3, Created: 1, Updated: 1, Unchanged: 1, Written: 2},
+			want: []models.MetricsWithValue{
+				{ID: "c", MType: "counter", Delta: 5},
+				{ID: "old", MType: "counter", Delta: 1},
+				{ID: "g", MType: "gauge", Value: 2},
+				{ID: "new", MType: "gauge", Value: 1},
+			},
+		},
+		{
+			name:       "merge into database in batches",
+			dst:        newMemory,
+			opts:       MigrateOptions{Policy: PolicyMerge, BatchSize: 1},
+			wantResult: MigrateResult{Read: 3, Created: 1, Updated: 1, Unchanged: 1, Written: 2},
+			want: []models.MetricsWithValue{
+				{ID: "c", MType: "counter", Delta: 8},
+				{ID: "old", MType: "counter", Delta: 1},
+				{ID: "g", MType: "gauge", Value: 2},
+				{ID: "new", MType: "gauge", Value: 1},
+			},
+		},
+		{
+			name:       "dry run",
+			dst:        newFile,
+			opts:       MigrateOptions{Policy: PolicyOverwrite, DryRun: true},
+			wantResult: MigrateResult{Read: 3, Created: 1, Updated: 1, Unchanged: 1},
+			want: []models.MetricsWithValue{
+				{ID: "c", MType: "counter", Delta: 3},
+				{ID: "old", MType: "counter", Delta: 1},
+				{ID: "g", MType: "gauge", Value: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := inmemory.NewStorage()
+			src.UpdateList(ctx, source)
+			dst := tt.dst(t)
+
+			var progress bytes.Buffer
+			tt.opts.Progress = &progress
+
+			result, err := Migrate(ctx, src, dst, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.wantResult.Mismatched = result.Mismatched
+			if !reflect.DeepEqual(result, tt.wantResult) {
+				t.Errorf("Migrate() = %+v, want %+v", result, tt.wantResult)
+			}
+			if !strings.Contains(progress.String(), "read 3 metrics from source") {
+				t.Errorf("progress output = %q", progress.String())
+			}
+
+			got, err := dst.GetList(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sortList(got), sortList(tt.want)) {
+				t.Errorf("destination = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// busyStorage is a destination some server keeps writing to.
+type busyStorage struct {
+	*inmemory.MemStorage
+}
+
+func (s busyStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	if err := s.MemStorage.UpdateChanged(ctx, list); err != nil {
+		return err
+	}
+	return s.MemStorage.Update(ctx, models.MetricsWithValue{ID: "live", MType: "counter", Delta: 1})
+}
+
+func TestMigrate_verifyBusyDestination(t *testing.T) {
+	ctx := context.Background()
+
+	src := inmemory.NewStorage()
+	src.Update(ctx, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1})
+
+	dst := busyStorage{inmemory.NewStorage()}
+	dst.Update(ctx, models.MetricsWithValue{ID: "live", MType: "counter", Delta: 1})
+
+	result, err := Migrate(ctx, src, mainBackup{dst}, MigrateOptions{Policy: PolicyOverwrite})
+	if err != nil {
+		t.Fatalf("Migrate() error = %v, mismatched %v", err, result.Mismatched)
+	}
+}
+
+func TestMigrate_verify(t *testing.T) {
+	ctx := context.Background()
+
+	src := inmemory.NewStorage()
+	src.Update(ctx, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1})
+
+	result, err := Migrate(ctx, src, lossyBackup{}, MigrateOptions{Policy: PolicyOverwrite})
+	if !errors.Is(err, ErrVerifyMismatched) {
+		t.Fatalf("Migrate() error = %v, want %v", err, ErrVerifyMismatched)
+	}
+	if want := []string{"counter/c"}; !reflect.DeepEqual(result.Mismatched, want) {
+		t.Errorf("Migrate().Mismatched = %v, want %v", result.Mismatched, want)
+	}
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/bolt"
+	"github.com/h3ll0kitt1/observability/internal/storage/file"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+	"github.com/h3ll0kitt1/observability/internal/storage/sql"
+	"github.com/h3ll0kitt1/observability/internal/storage/sqlite"
+)
+
+// Opener creates storage for URL. Storages that can serve as main storage
+// must implement MainStorage.
+type Opener func(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error)
+
+var (
+	ErrUnknownScheme = errors.New("unknown storage scheme")
+	ErrNotMain       = errors.New("storage can't be used as main storage")
+	ErrNotBackup     = errors.New("storage can't be used as backup storage")
+)
+
+var (
+	openers   = make(map[string]Opener)
+	openersMu sync.RWMutex
+)
+
+func init() {
+	Register("memory", openMemory)
+	Register("file", openFile)
+	Register("bolt", openBolt)
+	Register("sqlite", openSQLite)
+	Register("postgres", openPostgres)
+	Register("postgresql", openPostgres)
+}
+
+// Register makes storage available under URL scheme, it replaces opener
+// registered for the scheme before.
+func Register(scheme string, open Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+	openers[scheme] = open
+}
+
+func open(rawURL string, cfg *config.ServerConfig) (BackupStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	openersMu.RLock()
+	opener, ok := openers[u.Scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownScheme, u.Scheme)
+	}
+	return opener(u, cfg)
+}
+
+func OpenMainStorage(rawURL string, cfg *config.ServerConfig) (MainStorage, error) {
+	s, err := open(rawURL, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	main, ok := s.(MainStorage)
+	if !ok {
+		s.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotMain, rawURL)
+	}
+	return main, nil
+}
+
+func OpenBackupStorage(rawURL string, cfg *config.ServerConfig) (BackupStorage, error) {
+	s, err := open(rawURL, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if main, ok := s.(MainStorage); ok {
+		if _, ok := main.(IncrementalBackup); !ok {
+			s.Close()
+			return nil, fmt.Errorf("%w: %s stores no values as they are", ErrNotBackup, rawURL)
+		}
+		return mainBackup{main}, nil
+	}
+	return s, nil
+}
+
+// openMemory takes number of shards from memory://?shards=N or from
+// -memory-shards.
+func openMemory(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error) {
+	shards := cfg.MemoryShards
+	if value := u.Query().Get("shards"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid number of shards %q", value)
+		}
+		shards = n
+	}
+	return newMemoryStorage(shards), nil
+}
+
+// newMemoryStorage shards storage when asked to, a single lock per metric
+// type is enough for small loads.
+func newMemoryStorage(shards int) MainStorage {
+	if shards > 0 {
+		return inmemory.NewShardedStorage(shards)
+	}
+	return inmemory.NewStorage()
+}
+
+func openFile(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error) {
+	return newFileStorage(urlPath(u), cfg)
+}
+
+func openBolt(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error) {
+	return bolt.NewStorage(urlPath(u))
+}
+
+func openSQLite(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error) {
+	return sqlite.NewStorage(urlPath(u), cfg.DBAutoMigrate)
+}
+
+// urlPath accepts both file:///abs/path and file:rel/path forms.
+func urlPath(u *url.URL) string {
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	}
+	if u.Host != "" {
+		path = u.Host + u.Path
+	}
+	return path
+}
+
+func openPostgres(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error) {
+	return openDatabase(u.String(), cfg)
+}
+
+// openDatabase connects to postgres through native pgx pool with -db-pool
+// and through database/sql otherwise.
+func openDatabase(dsn string, cfg *config.ServerConfig) (MainStorage, error) {
+	c := *cfg
+	c.Database = dsn
+	if c.DBPool {
+		return sql.NewPoolStorage(&c)
+	}
+	return sql.NewStorage(&c)
+}
+
+func newFileStorage(path string, cfg *config.ServerConfig) (*file.FileStorage, error) {
+	key, err := file.LoadKey(cfg.BackupKey, cfg.BackupKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("backup key: %w", err)
+	}
+	codec, err := file.NewCodec(file.Compression(cfg.BackupCompression), key)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := file.NewStorage(path)
+	fs.SetCodec(codec)
+	fs.SetCompactAfter(cfg.BackupCompact)
+	fs.SetRetention(file.Retention{
+		Interval:   cfg.SnapshotInterval,
+		KeepLast:   cfg.SnapshotKeepLast,
+		KeepHourly: cfg.SnapshotKeepHourly,
+		KeepDaily:  cfg.SnapshotKeepDaily,
+	})
+	return fs, nil
+}
+
+// mainBackup lets main storage hold backup. UpdateList of main storage adds
+// counter deltas up, while backup has to store values as they are, so only
+// storages implementing IncrementalBackup are wrapped.
+type mainBackup struct {
+	MainStorage
+}
+
+func (b mainBackup) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	return b.MainStorage.(IncrementalBackup).UpdateChanged(ctx, list)
+}
+
+func (b mainBackup) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	return b.UpdateList(ctx, list)
+}
+
+// nopBackup is used when backup is disabled.
+type nopBackup struct{}
+
+func (nopBackup) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	return []models.MetricsWithValue{}, nil
+}
+
+func (nopBackup) UpdateList(ctx context.Context, list []models.MetricsWithValue) error { return nil }
+
+func (nopBackup) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error { return nil }
+
+func (nopBackup) Close() error { return nil }
+
+func (nopBackup) SetRetryCount(attempts int) {}
+
+func (nopBackup) SetRetryStartWaitTime(sleep time.Duration) {}
+
+func (nopBackup) SetRetryIncreaseWaitTime(delta time.Duration) {}
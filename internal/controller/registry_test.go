@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/file"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+func TestOpenMainStorage(t *testing.T) {
+	Register("test", func(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error) {
+		return inmemory.NewStorage(), nil
+	})
+	t.Cleanup(func() {
+		openersMu.Lock()
+		defer openersMu.Unlock()
+		delete(openers, "test")
+	})
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{
+			name: "memory",
+			url:  "memory://",
+		},
+		{
+			name: "sharded memory",
+			url:  "memory://?shards=16",
+		},
+		{
+			name: "registered scheme",
+			url:  "test://anything",
+		},
+		{
+			name: "bolt",
+			url:  "bolt://" + filepath.Join(t.TempDir(), "metrics.db"),
+		},
+		{
+			name: "sqlite",
+			url:  "sqlite://" + filepath.Join(t.TempDir(), "metrics.sqlite"),
+		},
+		{
+			name:    "file is not main storage",
+			url:     "file://" + filepath.Join(t.TempDir(), "metrics-db.json"),
+			wantErr: ErrNotMain,
+		},
+		{
+			name:    "unknown scheme",
+			url:     "mysql://localhost/metrics",
+			wantErr: ErrUnknownScheme,
+		},
+	}
+
+	cfg := config.NewServerConfig()
+	cfg.DBAutoMigrate = true
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := OpenMainStorage(tt.url, cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenMainStorage(%q) error = %v, want %v", tt.url, err, tt.wantErr)
+			}
+			if err == nil {
+				s.Close()
+			}
+		})
+	}
+}
+
+func TestOpenBackupStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{
+			name: "file",
+			url:  "file://" + filename,
+		},
+		{
+			name: "memory",
+			url:  "memory://",
+		},
+		{
+			name: "sharded memory",
+			url:  "memory://?shards=4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := OpenBackupStorage(tt.url, config.NewServerConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+
+			// Backup keeps values as they are, counters are not added up.
+			list := []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: 5}}
+			for i := 0; i < 2; i++ {
+				if err := b.UpdateList(ctx, list); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := b.GetList(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, list) {
+				t.Errorf("GetList() = %v, want %v", got, list)
+			}
+		})
+	}
+}
+
+func TestOpenBackupStorage_addsUp(t *testing.T) {
+	// MainStorage hides UpdateChanged of the wrapped storage.
+	Register("adding", func(u *url.URL, cfg *config.ServerConfig) (BackupStorage, error) {
+		return struct{ MainStorage }{inmemory.NewStorage()}, nil
+	})
+	t.Cleanup(func() {
+		openersMu.Lock()
+		defer openersMu.Unlock()
+		delete(openers, "adding")
+	})
+
+	if _, err := OpenBackupStorage("adding://", config.NewServerConfig()); !errors.Is(err, ErrNotBackup) {
+		t.Errorf("OpenBackupStorage() error = %v, want %v", err, ErrNotBackup)
+	}
+}
+
+func TestNewStorageManager_storages(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	tests := []struct {
+		name        string
+		cfg         config.ServerConfig
+		wantErr     error
+		checkBackup func(BackupStorage) bool
+	}{
+		{
+			name: "file backup by default",
+			cfg:  config.ServerConfig{FileStoragePath: filename},
+			checkBackup: func(b BackupStorage) bool {
+				_, ok := b.(*file.FileStorage)
+				return ok
+			},
+		},
+		{
+			name: "backup disabled",
+			cfg:  config.ServerConfig{},
+			checkBackup: func(b BackupStorage) bool {
+				_, ok := b.(nopBackup)
+				return ok
+			},
+		},
+		{
+			name: "memory backup",
+			cfg:  config.ServerConfig{FileStoragePath: filename, BackupStorage: "memory://"},
+			checkBackup: func(b BackupStorage) bool {
+				_, ok := b.(mainBackup)
+				return ok
+			},
+		},
+		{
+			name:    "snapshots need file backup",
+			cfg:     config.ServerConfig{BackupStorage: "memory://", RestoreFrom: "snapshot"},
+			wantErr: ErrSnapshotsUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := NewStorageManager(&tt.cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewStorageManager() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer sm.Close()
+
+			if backup := sm.(*SyncController).backup; !tt.checkBackup(backup) {
+				t.Errorf("backup storage = %T", backup)
+			}
+		})
+	}
+}
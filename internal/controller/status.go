@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type RestoreStatus string
+
+const (
+	RestoreSkipped RestoreStatus = "skipped"
+	RestoreOK      RestoreStatus = "ok"
+	RestoreFailed  RestoreStatus = "failed"
+)
+
+var ErrDegraded = errors.New("backup keeps failing, updates are rejected")
+
+type Status struct {
+	Started             time.Time
+	StoreInterval       time.Duration
+	LastFlush           time.Time
+	LastFlushError      error
+	ConsecutiveFailures int
+	Degraded            bool
+	Restore             RestoreStatus
+	RestoreError        error
+}
+
+type statusTracker struct {
+	status       Status
+	degradeAfter int
+	logger       *zap.SugaredLogger
+	mu           sync.Mutex
+}
+
+func newStatusTracker(storeInterval time.Duration) statusTracker {
+	return statusTracker{
+		status: Status{
+			Started:       time.Now(),
+			StoreInterval: storeInterval,
+			Restore:       RestoreSkipped,
+		},
+		logger: zap.NewNop().Sugar(),
+	}
+}
+
+func (t *statusTracker) SetLogger(logger *zap.SugaredLogger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.logger = logger
+}
+
+// SetDegradeAfter sets number of consecutive failed flushes after which
+// updates are rejected with ErrDegraded, 0 never rejects them.
+func (t *statusTracker) SetDegradeAfter(failures int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.degradeAfter = failures
+}
+
+func (t *statusTracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+func (t *statusTracker) flushed(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.LastFlushError = err
+	if err != nil {
+		t.status.ConsecutiveFailures++
+		t.logger.Errorw("error",
+			"flush backup", err,
+			"consecutive_failures", t.status.ConsecutiveFailures,
+		)
+
+		if !t.status.Degraded && t.degradeAfter > 0 && t.status.ConsecutiveFailures >= t.degradeAfter {
+			t.status.Degraded = true
+			t.logger.Warnw("backup degraded, rejecting updates",
+				"consecutive_failures", t.status.ConsecutiveFailures,
+			)
+		}
+		return
+	}
+
+	if t.status.ConsecutiveFailures > 0 {
+		t.logger.Infow("backup recovered",
+			"failed_flushes", t.status.ConsecutiveFailures,
+		)
+	}
+	t.status.LastFlush = time.Now()
+	t.status.ConsecutiveFailures = 0
+	t.status.Degraded = false
+}
+
+func (t *statusTracker) degraded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status.Degraded
+}
+
+func (t *statusTracker) restored(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.RestoreError = err
+	t.status.Restore = RestoreOK
+	if err != nil {
+		t.status.Restore = RestoreFailed
+	}
+}
+
+func (s Status) BackupAge(now time.Time) time.Duration {
+	last := s.LastFlush
+	if last.IsZero() {
+		last = s.Started
+	}
+	return now.Sub(last)
+}
+
+func (s Status) Stale(now time.Time, threshold time.Duration) bool {
+	if threshold <= 0 {
+		return false
+	}
+
+	// In sync mode backups are written only on updates, so an old backup
+	// is stale only when the latest attempt to write it has failed.
+	if s.StoreInterval == 0 && s.LastFlushError == nil {
+		return false
+	}
+	return s.BackupAge(now) > threshold
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/file"
+	"github.com/h3ll0kitt1/observability/internal/storage/inmemory"
+)
+
+func TestStatus_Stale(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		status    Status
+		threshold time.Duration
+		want      bool
+	}{
+		{
+			name: "async flushed recently",
+			status: Status{
+				Started:       now.Add(-time.Hour),
+				StoreInterval: time.Minute,
+				LastFlush:     now.Add(-time.Minute),
+			},
+			threshold: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name: "async not flushed for long",
+			status: Status{
+				Started:       now.Add(-time.Hour),
+				StoreInterval: time.Minute,
+				LastFlush:     now.Add(-10 * time.Minute),
+			},
+			threshold: 5 * time.Minute,
+			want:      true,
+		},
+		{
+			name: "async never flushed since recent start",
+			status: Status{
+				Started:       now.Add(-time.Minute),
+				StoreInterval: time.Minute,
+			},
+			threshold: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name: "sync without updates",
+			status: Status{
+				Started:   now.Add(-time.Hour),
+				LastFlush: now.Add(-time.Hour),
+			},
+			threshold: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name: "sync failing for long",
+			status: Status{
+				Started:        now.Add(-time.Hour),
+				LastFlush:      now.Add(-time.Hour),
+				LastFlushError: errors.New("read-only file system"),
+			},
+			threshold: 5 * time.Minute,
+			want:      true,
+		},
+		{
+			name: "check disabled",
+			status: Status{
+				Started:       now.Add(-time.Hour),
+				StoreInterval: time.Minute,
+			},
+			threshold: 0,
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.Stale(now, tt.threshold); got != tt.want {
+				t.Errorf("Stale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusTracker_flushed(t *testing.T) {
+	tests := []struct {
+		name         string
+		degradeAfter int
+		flushes      []error
+		wantFailures int
+		wantDegraded bool
+	}{
+		{
+			name:         "failures counted",
+			degradeAfter: 0,
+			flushes:      []error{errors.New("disk full"), errors.New("disk full"), errors.New("disk full")},
+			wantFailures: 3,
+			wantDegraded: false,
+		},
+		{
+			name:         "degraded after threshold",
+			degradeAfter: 2,
+			flushes:      []error{errors.New("disk full"), errors.New("disk full")},
+			wantFailures: 2,
+			wantDegraded: true,
+		},
+		{
+			name:         "recovered",
+			degradeAfter: 2,
+			flushes:      []error{errors.New("disk full"), errors.New("disk full"), nil},
+			wantFailures: 0,
+			wantDegraded: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newStatusTracker(time.Minute)
+			tracker.SetDegradeAfter(tt.degradeAfter)
+
+			for _, err := range tt.flushes {
+				tracker.flushed(err)
+			}
+
+			status := tracker.Status()
+			if status.ConsecutiveFailures != tt.wantFailures {
+				t.Errorf("ConsecutiveFailures = %d, want %d", status.ConsecutiveFailures, tt.wantFailures)
+			}
+			if status.Degraded != tt.wantDegraded {
+				t.Errorf("Degraded = %v, want %v", status.Degraded, tt.wantDegraded)
+			}
+		})
+	}
+}
+
+func TestSyncController_degraded(t *testing.T) {
+	ctx := context.Background()
+	backup := &flakyBackup{FileStorage: file.NewStorage(filepath.Join(t.TempDir(), "metrics-db.json"))}
+	backup.fail.Store(true)
+
+	c := &SyncController{
+		storage:       inmemory.NewStorage(),
+		backup:        backup,
+		dirty:         newDirtySet(backup),
+		statusTracker: newStatusTracker(0),
+	}
+	c.SetDegradeAfter(2)
+
+	metric := models.MetricsWithValue{ID: "g", MType: "gauge", Value: 1}
+	for i := 0; i < 2; i++ {
+		if err := c.Update(ctx, metric); err == nil || errors.Is(err, ErrDegraded) {
+			t.Fatalf("Update() error = %v, want flush error", err)
+		}
+	}
+	if err := c.Update(ctx, metric); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("Update() in degraded mode error = %v, want %v", err, ErrDegraded)
+	}
+
+	backup.fail.Store(false)
+	if err := c.Update(ctx, metric); err != nil {
+		t.Fatalf("Update() after recovery error = %v, want nil", err)
+	}
+	if c.Status().Degraded {
+		t.Errorf("Status().Degraded = true after recovery")
+	}
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/h3ll0kitt1/observability/internal/models"
@@ -18,8 +19,49 @@ type MainStorage interface {
 type BackupStorage interface {
 	GetList(ctx context.Context) ([]models.MetricsWithValue, error)
 	UpdateList(ctx context.Context, list []models.MetricsWithValue) error
+	Close() error
 
 	SetRetryCount(attempts int)
 	SetRetryStartWaitTime(sleep time.Duration)
 	SetRetryIncreaseWaitTime(delta time.Duration)
 }
+
+// IncrementalBackup stores current values of changed series only, without
+// rewriting the rest of the backup. UpdateChanged stores values as they
+// are: unlike UpdateList of main storage, it replaces stored counters
+// instead of adding to them.
+type IncrementalBackup interface {
+	UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error
+}
+
+type WriteAheadLog interface {
+	Append(list []models.MetricsWithValue) error
+	Rotate() (int, error)
+	Commit(seq int) error
+	Replay(checkpoint int) ([]models.MetricsWithValue, error)
+	Close() error
+}
+
+// CheckpointBackup stores sequence number of the last log segment a backup
+// contains together with the backup, so that segments left over from a
+// crash before Commit are not replayed on top of it again.
+type CheckpointBackup interface {
+	SetCheckpoint(seq int)
+	Checkpoint(ctx context.Context) (int, error)
+}
+
+var ErrCheckpointUnsupported = errors.New("write-ahead log needs backup storage that keeps checkpoints")
+
+type SnapshotStorage interface {
+	ListSnapshots() ([]models.Snapshot, error)
+}
+
+var ErrSnapshotsUnsupported = errors.New("backup storage does not keep snapshots")
+
+func listSnapshots(backup BackupStorage) ([]models.Snapshot, error) {
+	s, ok := backup.(SnapshotStorage)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+	return s.ListSnapshots()
+}
@@ -2,18 +2,39 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
+	"go.uber.org/zap"
+
 	"github.com/h3ll0kitt1/observability/internal/models"
 )
 
 type SyncController struct {
 	backup  BackupStorage
 	storage MainStorage
+	restore BackupStorage
+	dirty   dirtySet
+	mu      sync.Mutex
+	statusTracker
+	cardinalityTracker
 }
 
 func (c *SyncController) Load() error {
-	list, err := c.backup.GetList(context.Background())
+	err := c.load()
+	c.reseed()
+	c.restored(err)
+	return err
+}
+
+func (c *SyncController) load() error {
+	source := c.backup
+	if c.restore != nil {
+		source = c.restore
+	}
+
+	list, err := source.GetList(context.Background())
 	if err != nil {
 		return err
 	}
@@ -24,11 +45,35 @@ func (c *SyncController) Load() error {
 	return nil
 }
 
-func (c *SyncController) Run() {
+func (c *SyncController) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (c *SyncController) Snapshots() ([]models.Snapshot, error) {
+	return listSnapshots(c.backup)
+}
+
+func (c *SyncController) CacheStats() (CacheStats, bool) {
+	return cacheStats(c.storage)
+}
+
+func (c *SyncController) PoolStats() (PoolStats, bool) {
+	return poolStats(c.storage)
+}
+
+func (c *SyncController) Cardinality(ctx context.Context, prefix string) (Cardinality, error) {
+	return c.report(ctx, c.storage, prefix)
+}
+
+func (c *SyncController) SetLogger(logger *zap.SugaredLogger) {
+	c.statusTracker.SetLogger(logger)
+	setStorageLogger(c.storage, logger)
 }
 
 func (c *SyncController) Set(newMainStorage MainStorage) {
 	c.storage = newMainStorage
+	c.reseed()
 }
 
 func (c *SyncController) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
@@ -48,16 +93,36 @@ func (c *SyncController) GetList(ctx context.Context) ([]models.MetricsWithValue
 }
 
 func (c *SyncController) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	if err := c.probe(); err != nil {
+		return err
+	}
+	accepted, claimed, err := c.admit(ctx, c.storage, []models.MetricsWithValue{metric})
+	if err != nil || len(accepted) == 0 {
+		return err
+	}
 	if err := c.storage.Update(ctx, metric); err != nil {
+		c.forget(claimed)
 		return err
 	}
+	c.confirm(claimed)
+	c.dirty.mark(metric)
 	return c.flush()
 }
 
 func (c *SyncController) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	if err := c.probe(); err != nil {
+		return err
+	}
+	list, claimed, err := c.admit(ctx, c.storage, list)
+	if err != nil || len(list) == 0 {
+		return err
+	}
 	if err := c.storage.UpdateList(ctx, list); err != nil {
+		c.forget(claimed)
 		return err
 	}
+	c.confirm(claimed)
+	c.dirty.mark(list...)
 	return c.flush()
 }
 
@@ -65,6 +130,10 @@ func (c *SyncController) Ping() error {
 	return c.storage.Ping()
 }
 
+func (c *SyncController) Close() error {
+	return errors.Join(c.storage.Close(), c.backup.Close())
+}
+
 func (c *SyncController) SetRetryCount(attempts int) {
 	c.storage.SetRetryCount(attempts)
 	c.backup.SetRetryCount(attempts)
@@ -80,14 +149,37 @@ func (c *SyncController) SetRetryIncreaseWaitTime(delta time.Duration) {
 	c.backup.SetRetryIncreaseWaitTime(delta)
 }
 
-func (c *SyncController) flush() error {
-	list, err := c.storage.GetList(context.Background())
-	if err != nil {
-		return err
+// probe retries the backup before an update is accepted in degraded mode,
+// there is no other moment to find out it has recovered.
+func (c *SyncController) probe() error {
+	if !c.degraded() {
+		return nil
 	}
-
-	if err := c.backup.UpdateList(context.Background(), list); err != nil {
-		return err
+	if err := c.flush(); err != nil {
+		return ErrDegraded
 	}
 	return nil
 }
+
+func (c *SyncController) flush() error {
+	err := c.writeBackup()
+	c.flushed(err)
+	return err
+}
+
+func (c *SyncController) writeBackup() error {
+	// Values are read and written under one lock, so a concurrent flush
+	// can't overwrite newer values with the ones it read earlier.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx := context.Background()
+	keys, incremental := c.dirty.take()
+
+	list, err := backupList(ctx, c.storage, keys, incremental)
+	if err == nil {
+		err = storeBackup(ctx, c.backup, list, incremental)
+	}
+	c.dirty.done(keys, err)
+	return err
+}
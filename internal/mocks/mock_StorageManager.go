@@ -12,6 +12,7 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	controller "github.com/h3ll0kitt1/observability/internal/controller"
 	models "github.com/h3ll0kitt1/observability/internal/models"
+	zap "go.uber.org/zap"
 )
 
 // MockStorageManager is a mock of StorageManager interface.
@@ -37,6 +38,50 @@ func (m *MockStorageManager) EXPECT() *MockStorageManagerMockRecorder {
 	return m.recorder
 }
 
+// CacheStats mocks base method.
+func (m *MockStorageManager) CacheStats() (controller.CacheStats, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CacheStats")
+	ret0, _ := ret[0].(controller.CacheStats)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// CacheStats indicates an expected call of CacheStats.
+func (mr *MockStorageManagerMockRecorder) CacheStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CacheStats", reflect.TypeOf((*MockStorageManager)(nil).CacheStats))
+}
+
+// Cardinality mocks base method.
+func (m *MockStorageManager) Cardinality(arg0 context.Context, arg1 string) (controller.Cardinality, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Cardinality", arg0, arg1)
+	ret0, _ := ret[0].(controller.Cardinality)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Cardinality indicates an expected call of Cardinality.
+func (mr *MockStorageManagerMockRecorder) Cardinality(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cardinality", reflect.TypeOf((*MockStorageManager)(nil).Cardinality), arg0, arg1)
+}
+
+// Close mocks base method.
+func (m *MockStorageManager) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockStorageManagerMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorageManager)(nil).Close))
+}
+
 // Get mocks base method.
 func (m *MockStorageManager) Get(arg0 context.Context, arg1 models.MetricsWithValue) (models.MetricsWithValue, error) {
 	m.ctrl.T.Helper()
@@ -95,16 +140,33 @@ func (mr *MockStorageManagerMockRecorder) Ping() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockStorageManager)(nil).Ping))
 }
 
+// PoolStats mocks base method.
+func (m *MockStorageManager) PoolStats() (controller.PoolStats, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PoolStats")
+	ret0, _ := ret[0].(controller.PoolStats)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// PoolStats indicates an expected call of PoolStats.
+func (mr *MockStorageManagerMockRecorder) PoolStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PoolStats", reflect.TypeOf((*MockStorageManager)(nil).PoolStats))
+}
+
 // Run mocks base method.
-func (m *MockStorageManager) Run() {
+func (m *MockStorageManager) Run(arg0 context.Context) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "Run")
+	ret := m.ctrl.Call(m, "Run", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // Run indicates an expected call of Run.
-func (mr *MockStorageManagerMockRecorder) Run() *gomock.Call {
+func (mr *MockStorageManagerMockRecorder) Run(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockStorageManager)(nil).Run))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockStorageManager)(nil).Run), arg0)
 }
 
 // Set mocks base method.
@@ -119,6 +181,30 @@ func (mr *MockStorageManagerMockRecorder) Set(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockStorageManager)(nil).Set), arg0)
 }
 
+// SetDegradeAfter mocks base method.
+func (m *MockStorageManager) SetDegradeAfter(arg0 int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetDegradeAfter", arg0)
+}
+
+// SetDegradeAfter indicates an expected call of SetDegradeAfter.
+func (mr *MockStorageManagerMockRecorder) SetDegradeAfter(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDegradeAfter", reflect.TypeOf((*MockStorageManager)(nil).SetDegradeAfter), arg0)
+}
+
+// SetLogger mocks base method.
+func (m *MockStorageManager) SetLogger(arg0 *zap.SugaredLogger) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetLogger", arg0)
+}
+
+// SetLogger indicates an expected call of SetLogger.
+func (mr *MockStorageManagerMockRecorder) SetLogger(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogger", reflect.TypeOf((*MockStorageManager)(nil).SetLogger), arg0)
+}
+
 // SetRetryCount mocks base method.
 func (m *MockStorageManager) SetRetryCount(arg0 int) {
 	m.ctrl.T.Helper()
@@ -155,6 +241,35 @@ func (mr *MockStorageManagerMockRecorder) SetRetryStartWaitTime(arg0 interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRetryStartWaitTime", reflect.TypeOf((*MockStorageManager)(nil).SetRetryStartWaitTime), arg0)
 }
 
+// Snapshots mocks base method.
+func (m *MockStorageManager) Snapshots() ([]models.Snapshot, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Snapshots")
+	ret0, _ := ret[0].([]models.Snapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Snapshots indicates an expected call of Snapshots.
+func (mr *MockStorageManagerMockRecorder) Snapshots() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Snapshots", reflect.TypeOf((*MockStorageManager)(nil).Snapshots))
+}
+
+// Status mocks base method.
+func (m *MockStorageManager) Status() controller.Status {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status")
+	ret0, _ := ret[0].(controller.Status)
+	return ret0
+}
+
+// Status indicates an expected call of Status.
+func (mr *MockStorageManagerMockRecorder) Status() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockStorageManager)(nil).Status))
+}
+
 // Update mocks base method.
 func (m *MockStorageManager) Update(arg0 context.Context, arg1 models.MetricsWithValue) error {
 	m.ctrl.T.Helper()
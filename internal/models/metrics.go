@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Metrics struct {
 	ID    string   `json:"id"`
 	MType string   `json:"type"`
@@ -39,3 +41,9 @@ func ToMetric(metric MetricsWithValue) Metrics {
 	}
 	return m
 }
+
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+}
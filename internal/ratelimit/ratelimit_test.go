@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(1, 2)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		name      string
+		key       string
+		advance   time.Duration
+		wantAllow bool
+		wantWait  time.Duration
+	}{
+		{
+			name:      "first request uses burst",
+			key:       "agent",
+			wantAllow: true,
+		},
+		{
+			name:      "second request uses burst",
+			key:       "agent",
+			wantAllow: true,
+		},
+		{
+			name:      "burst exhausted",
+			key:       "agent",
+			wantAllow: false,
+			wantWait:  time.Second,
+		},
+		{
+			name:      "other client has own bucket",
+			key:       "other",
+			wantAllow: true,
+		},
+		{
+			name:      "half a token refilled",
+			key:       "agent",
+			advance:   500 * time.Millisecond,
+			wantAllow: false,
+			wantWait:  500 * time.Millisecond,
+		},
+		{
+			name:      "token refilled",
+			key:       "agent",
+			advance:   500 * time.Millisecond,
+			wantAllow: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+
+			allow, wait := l.Allow(tt.key)
+			if allow != tt.wantAllow {
+				t.Errorf("Allow() = %v, want %v", allow, tt.wantAllow)
+			}
+			if wait != tt.wantWait {
+				t.Errorf("Allow() wait = %v, want %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestLimiter_sweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(1, 1)
+	l.now = func() time.Time { return now }
+
+	l.Allow("agent")
+	now = now.Add(2 * idleTimeout)
+	l.Allow("other")
+
+	if _, ok := l.buckets["agent"]; ok {
+		t.Errorf("sweep() kept idle bucket")
+	}
+	if _, ok := l.buckets["other"]; !ok {
+		t.Errorf("sweep() removed active bucket")
+	}
+}
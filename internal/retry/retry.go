@@ -0,0 +1,123 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy describes how an operation is retried. Wait before n-th retry is
+// (Wait + (n-1)*Increase) * Multiplier^(n-1), capped by MaxWait and spread
+// by Jitter. Policy is a value, retrying never changes it, so one policy
+// can be shared by concurrent calls.
+type Policy struct {
+	// Attempts is the number of retries after the first call.
+	Attempts   int
+	Wait       time.Duration
+	Increase   time.Duration
+	Multiplier float64
+	MaxWait    time.Duration
+	// MaxElapsed stops retrying once the next wait would end later than
+	// MaxElapsed after the first call, zero means no limit.
+	MaxElapsed time.Duration
+	// Jitter is a fraction of wait by which it is randomly shortened or
+	// lengthened, so that clients failed together don't retry together.
+	Jitter float64
+	// Retriable tells whether the call failed with err may succeed when
+	// repeated, nil retries every error.
+	Retriable func(err error) bool
+}
+
+type afterError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e afterError) Error() string { return e.err.Error() }
+
+func (e afterError) Unwrap() error { return e.err }
+
+// After wraps err with wait asked by the other side, such as Retry-After
+// header, it replaces the policy wait for the next retry.
+func After(err error, wait time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return afterError{err: err, wait: wait}
+}
+
+// Do calls f until it succeeds, fails with an error that is not retriable,
+// attempts run out or ctx is done.
+func Do(ctx context.Context, p Policy, f func(ctx context.Context) error) error {
+	_, err := DoValue(ctx, p, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, f(ctx)
+	})
+	return err
+}
+
+// DoValue is Do for calls returning a value.
+func DoValue[T any](ctx context.Context, p Policy, f func(ctx context.Context) (T, error)) (T, error) {
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		value, err := f(ctx)
+		if err == nil {
+			return value, nil
+		}
+		if ctx.Err() != nil || (p.Retriable != nil && !p.Retriable(err)) {
+			return value, err
+		}
+		if attempt >= p.Attempts {
+			return value, fmt.Errorf("after %d attempts, last error: %w", attempt+1, err)
+		}
+
+		wait := p.wait(attempt)
+		var after afterError
+		if errors.As(err, &after) && after.wait > 0 {
+			wait = after.wait
+		}
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return value, fmt.Errorf("after %d attempts, last error: %w", attempt+1, err)
+		}
+
+		if ctxErr := sleep(ctx, wait); ctxErr != nil {
+			return value, fmt.Errorf("after %d attempts: %w, last error: %v", attempt+1, ctxErr, err)
+		}
+	}
+}
+
+// wait returns wait before retry following attempt, attempts count from 0.
+func (p Policy) wait(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	wait := float64(p.Wait+time.Duration(attempt)*p.Increase) * math.Pow(multiplier, float64(attempt))
+	if p.MaxWait > 0 && wait > float64(p.MaxWait) {
+		wait = float64(p.MaxWait)
+	}
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(wait)
+}
+
+func sleep(ctx context.Context, wait time.Duration) error {
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
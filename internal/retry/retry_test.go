@@ -0,0 +1,158 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTemporary = errors.New("temporary")
+
+func TestPolicy_wait(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   []time.Duration
+	}{
+		{
+			name:   "linear",
+			policy: Policy{Wait: time.Second, Increase: 2 * time.Second},
+			want:   []time.Duration{time.Second, 3 * time.Second, 5 * time.Second},
+		},
+		{
+			name:   "exponential capped",
+			policy: Policy{Wait: time.Second, Multiplier: 2, MaxWait: 5 * time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second},
+		},
+		{
+			name:   "no wait",
+			policy: Policy{},
+			want:   []time.Duration{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for attempt, want := range tt.want {
+				if got := tt.policy.wait(attempt); got != want {
+					t.Errorf("wait(%d) = %v, want %v", attempt, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicy_waitJitter(t *testing.T) {
+	p := Policy{Wait: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := p.wait(0); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("wait(0) = %v, want within 50%% of 1s", got)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    Policy
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "succeeds after retries",
+			policy:    Policy{Attempts: 3},
+			failures:  2,
+			err:       errTemporary,
+			wantCalls: 3,
+		},
+		{
+			name:      "attempts exhausted",
+			policy:    Policy{Attempts: 2},
+			failures:  5,
+			err:       errTemporary,
+			wantCalls: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "not retriable",
+			policy:    Policy{Attempts: 3, Retriable: func(err error) bool { return err == errTemporary }},
+			failures:  5,
+			err:       errors.New("permanent"),
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "max elapsed",
+			policy:    Policy{Attempts: 3, Wait: time.Hour, MaxElapsed: time.Minute},
+			failures:  5,
+			err:       errTemporary,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Do(context.Background(), tt.policy, func(ctx context.Context) error {
+				calls++
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Do() error = %v, want wrapping %v", err, tt.err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Do() made %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDo_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := Do(ctx, Policy{Attempts: 3, Wait: time.Hour}, func(ctx context.Context) error {
+		return errTemporary
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do() returned after %v, want right after cancel", elapsed)
+	}
+}
+
+func TestDoValue_after(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	got, err := DoValue(context.Background(), Policy{Attempts: 1, Wait: time.Hour}, func(ctx context.Context) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, After(errTemporary, 10*time.Millisecond)
+		}
+		return 42, nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 42 {
+		t.Errorf("DoValue() = %d, want 42", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DoValue() waited %v, want wait from After", elapsed)
+	}
+}
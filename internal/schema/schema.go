@@ -0,0 +1,361 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrBadFileName     = errors.New("migration file name must be <version>_<name>.up.sql or <version>_<name>.down.sql")
+	ErrDuplicate       = errors.New("duplicate migration version")
+	ErrMissingUp       = errors.New("migration has no up script")
+	ErrUnknownVersion  = errors.New("database has migration unknown to this build")
+	ErrIrreversible    = errors.New("migration has no down script")
+	ErrSchemaOutdated  = errors.New("database schema is outdated, run migrations")
+	migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Dialect holds what differs between databases sharing the migrations
+// runner.
+type Dialect interface {
+	// Lock serializes migrations of several instances, it is held on conn
+	// until Unlock.
+	Lock(ctx context.Context, conn *sql.Conn) error
+	Unlock(ctx context.Context, conn *sql.Conn) error
+	// TableExists lets Status tell a fresh database from a broken one
+	// without creating anything.
+	TableExists(ctx context.Context, conn *sql.Conn, name string) (bool, error)
+	Placeholder(n int) string
+	// SingleTx runs whole Up or Down in one transaction and reads applied
+	// versions inside it, otherwise every migration gets a transaction of
+	// its own.
+	SingleTx() bool
+}
+
+// querier is a connection or a transaction migrations are run on.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+	ownsDB     bool
+}
+
+// New reads migrations from the root of fsys, usually an embed.FS.
+func New(db *sql.DB, dialect Dialect, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+	}, nil
+}
+
+// Open is New for db opened only for migrating: the migrator owns it, so db
+// is closed on error and by Close.
+func Open(db *sql.DB, dialect Dialect, fsys fs.FS) (*Migrator, error) {
+	m, err := New(db, dialect, fsys)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	m.ownsDB = true
+	return m, nil
+}
+
+// Close closes db of a migrator made by Open, the one given to New stays
+// open.
+func (m *Migrator) Close() error {
+	if !m.ownsDB {
+		return nil
+	}
+	return m.db.Close()
+}
+
+// Migrate brings schema of db up to date, without autoMigrate it only
+// checks that nothing is pending and fails with ErrSchemaOutdated otherwise.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect, fsys fs.FS, autoMigrate bool) error {
+	m, err := New(db, dialect, fsys)
+	if err != nil {
+		return err
+	}
+
+	if autoMigrate {
+		_, err := m.Up(ctx)
+		return err
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		if !s.Applied {
+			return fmt.Errorf("%w: %d_%s is not applied", ErrSchemaOutdated, s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadFileName, entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadFileName, entry.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: %d", ErrDuplicate, version)
+		}
+
+		switch match[3] {
+		case "up":
+			m.Up = string(data)
+		case "down":
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMissingUp, m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies all pending migrations and returns how many were applied. It
+// refuses to touch a database migrated by a newer build.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(q querier, versions map[int64]bool) error {
+		if err := m.checkKnown(versions); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if versions[migration.Version] {
+				continue
+			}
+
+			insert := fmt.Sprintf("INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)",
+				m.dialect.Placeholder(1), m.dialect.Placeholder(2), m.dialect.Placeholder(3))
+			err := m.apply(ctx, q, migration.Up, insert, migration.Version, migration.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts up to steps latest applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.locked(ctx, func(q querier, versions map[int64]bool) error {
+		if err := m.checkKnown(versions); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if !versions[migration.Version] {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %d_%s", ErrIrreversible, migration.Version, migration.Name)
+			}
+
+			remove := fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %s", m.dialect.Placeholder(1))
+			if err := m.apply(ctx, q, migration.Down, remove, migration.Version); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status only reads the database: it takes no lock and creates nothing, a
+// database without schema_migrations has every migration pending.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	versions := make(map[int64]bool)
+	exists, err := m.dialect.TableExists(ctx, conn, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if versions, err = appliedVersions(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status = append(status, MigrationStatus{
+			Migration: migration,
+			Applied:   versions[migration.Version],
+		})
+	}
+	return status, nil
+}
+
+func (m *Migrator) checkKnown(versions map[int64]bool) error {
+	known := make(map[int64]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+	}
+	for version := range versions {
+		if !known[version] {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) locked(ctx context.Context, f func(q querier, versions map[int64]bool) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.dialect.Lock(ctx, conn); err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+	defer m.dialect.Unlock(context.Background(), conn)
+
+	var q querier = conn
+	var tx *sql.Tx
+	if m.dialect.SingleTx() {
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	_, err = q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint primary key,
+		name varchar(256) not null,
+		applied_at timestamp not null)`)
+	if err != nil {
+		return err
+	}
+
+	versions, err := appliedVersions(ctx, q)
+	if err != nil {
+		return err
+	}
+	if err := f(q, versions); err != nil {
+		return err
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// apply runs script and records it, on a bare connection both are done in
+// a transaction of their own.
+func (m *Migrator) apply(ctx context.Context, q querier, script string, record string, args ...any) error {
+	if conn, ok := q.(*sql.Conn); ok {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := m.apply(ctx, tx, script, record, args...); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if _, err := q.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, record, args...)
+	return err
+}
+
+func appliedVersions(ctx context.Context, q querier) (map[int64]bool, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions[version] = true
+	}
+	return versions, rows.Err()
+}
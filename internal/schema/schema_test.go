@@ -0,0 +1,295 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	_ "modernc.org/sqlite"
+)
+
+// testDialect runs migrations on sqlite, which needs no lock.
+type testDialect struct {
+	singleTx bool
+}
+
+func (testDialect) Lock(ctx context.Context, conn *sql.Conn) error { return nil }
+
+func (testDialect) Unlock(ctx context.Context, conn *sql.Conn) error { return nil }
+
+func (testDialect) TableExists(ctx context.Context, conn *sql.Conn, name string) (bool, error) {
+	var count int
+	err := conn.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count > 0, err
+}
+
+func (testDialect) Placeholder(n int) string { return "?" }
+
+func (d testDialect) SingleTx() bool { return d.singleTx }
+
+func newTestMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, *sql.DB) {
+	return newTestDialectMigrator(t, testDialect{}, fsys)
+}
+
+func newTestDialectMigrator(t *testing.T, dialect Dialect, fsys fstest.MapFS) (*Migrator, *sql.DB) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, dialect, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr error
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"0002_add_index.up.sql":        {Data: []byte("CREATE INDEX")},
+				"0001_create_metrics.up.sql":   {Data: []byte("CREATE TABLE")},
+				"0001_create_metrics.down.sql": {Data: []byte("DROP TABLE")},
+				"README.md":                    {Data: []byte("not a migration")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "create_metrics", Up: "CREATE TABLE", Down: "DROP TABLE"},
+				{Version: 2, Name: "add_index", Up: "CREATE INDEX"},
+			},
+		},
+		{
+			name: "bad file name",
+			fsys: fstest.MapFS{
+				"create_metrics.sql": {Data: []byte("CREATE TABLE")},
+			},
+			wantErr: ErrBadFileName,
+		},
+		{
+			name: "duplicate version",
+			fsys: fstest.MapFS{
+				"0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE")},
+				"0001_create_samples.up.sql": {Data: []byte("CREATE TABLE")},
+			},
+			wantErr: ErrDuplicate,
+		},
+		{
+			name: "missing up",
+			fsys: fstest.MapFS{
+				"0001_create_metrics.down.sql": {Data: []byte("DROP TABLE")},
+			},
+			wantErr: ErrMissingUp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_metrics.up.sql":   {Data: []byte("CREATE TABLE metrics (id text)")},
+		"0001_create_metrics.down.sql": {Data: []byte("DROP TABLE metrics")},
+		"0002_create_samples.up.sql":   {Data: []byte("CREATE TABLE samples (id text)")},
+		"0002_create_samples.down.sql": {Data: []byte("DROP TABLE samples")},
+	}
+	m, db := newTestMigrator(t, fsys)
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 2 || !tableExists(t, db, "metrics") || !tableExists(t, db, "samples") {
+		t.Fatalf("Up() applied %d migrations, want 2 with both tables created", applied)
+	}
+
+	if applied, err := m.Up(ctx); err != nil || applied != 0 {
+		t.Errorf("second Up() = %d, %v, want nothing applied", applied, err)
+	}
+
+	reverted, err := m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted != 1 || !tableExists(t, db, "metrics") || tableExists(t, db, "samples") {
+		t.Errorf("Down(1) reverted %d migrations, want only the latest", reverted)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []bool{status[0].Applied, status[1].Applied}
+	if want := []bool{true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Status() applied = %v, want %v", got, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics (id text)")},
+	}
+	_, db := newTestMigrator(t, fsys)
+	ctx := context.Background()
+
+	if err := Migrate(ctx, db, testDialect{}, fsys, false); !errors.Is(err, ErrSchemaOutdated) {
+		t.Errorf("Migrate() without autoMigrate error = %v, want %v", err, ErrSchemaOutdated)
+	}
+	if tableExists(t, db, "metrics") || tableExists(t, db, "schema_migrations") {
+		t.Error("Migrate() without autoMigrate changed the database")
+	}
+
+	if err := Migrate(ctx, db, testDialect{}, fsys, true); err != nil {
+		t.Fatalf("Migrate() with autoMigrate error = %v", err)
+	}
+	if err := Migrate(ctx, db, testDialect{}, fsys, false); err != nil {
+		t.Errorf("Migrate() of migrated database error = %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics (id text)")},
+	}
+	open := func() *sql.DB {
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	db := open()
+	m, err := Open(db, testDialect{}, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err == nil {
+		t.Error("Close() of migrator made by Open left database open")
+	}
+
+	db = open()
+	if _, err := Open(db, testDialect{}, fstest.MapFS{"bad.sql": {}}); !errors.Is(err, ErrBadFileName) {
+		t.Fatalf("Open() with bad migrations error = %v, want %v", err, ErrBadFileName)
+	}
+	if err := db.Ping(); err == nil {
+		t.Error("failed Open() left database open")
+	}
+
+	m, db = newTestMigrator(t, fsys)
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Errorf("Close() of migrator made by New closed database: %v", err)
+	}
+}
+
+func TestMigrator_errors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("failed migration is rolled back", func(t *testing.T) {
+		m, db := newTestMigrator(t, fstest.MapFS{
+			"0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics (id text); INSERT INTO missing VALUES (1)")},
+		})
+		if _, err := m.Up(ctx); err == nil {
+			t.Fatal("Up() with broken migration succeeded")
+		}
+		if tableExists(t, db, "metrics") {
+			t.Error("Up() left table of failed migration")
+		}
+	})
+
+	t.Run("failed migration rolls back whole single transaction run", func(t *testing.T) {
+		m, db := newTestDialectMigrator(t, testDialect{singleTx: true}, fstest.MapFS{
+			"0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics (id text)")},
+			"0002_create_samples.up.sql": {Data: []byte("CREATE TABLE samples (id text); INSERT INTO missing VALUES (1)")},
+		})
+		if _, err := m.Up(ctx); err == nil {
+			t.Fatal("Up() with broken migration succeeded")
+		}
+		if tableExists(t, db, "metrics") || tableExists(t, db, "schema_migrations") {
+			t.Error("Up() kept changes of failed single transaction run")
+		}
+	})
+
+	t.Run("irreversible", func(t *testing.T) {
+		m, _ := newTestMigrator(t, fstest.MapFS{
+			"0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics (id text)")},
+		})
+		if _, err := m.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Down(ctx, 1); !errors.Is(err, ErrIrreversible) {
+			t.Errorf("Down() error = %v, want %v", err, ErrIrreversible)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		m, db := newTestMigrator(t, fstest.MapFS{
+			"0001_create_metrics.up.sql":   {Data: []byte("CREATE TABLE metrics (id text)")},
+			"0001_create_metrics.down.sql": {Data: []byte("DROP TABLE metrics")},
+		})
+		if _, err := m.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("INSERT INTO schema_migrations VALUES (9, 'newer', CURRENT_TIMESTAMP)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Down(ctx, 1); !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("Down() error = %v, want %v", err, ErrUnknownVersion)
+		}
+		if _, err := m.Up(ctx); !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("Up() error = %v, want %v", err, ErrUnknownVersion)
+		}
+	})
+
+	t.Run("status of fresh database", func(t *testing.T) {
+		m, db := newTestMigrator(t, fstest.MapFS{
+			"0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics (id text)")},
+		})
+		status, err := m.Status(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(status) != 1 || status[0].Applied {
+			t.Errorf("Status() = %v, want one pending migration", status)
+		}
+		if tableExists(t, db, "schema_migrations") {
+			t.Error("Status() created schema_migrations")
+		}
+	})
+}
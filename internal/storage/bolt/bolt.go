@@ -0,0 +1,178 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+var ErrUnknownMetric = errors.New("unknown metric")
+
+var (
+	counterBucket = []byte("counter")
+	gaugeBucket   = []byte("gauge")
+)
+
+// BoltStorage keeps metrics in a single bbolt file, one bucket per metric
+// type with values encoded as 8 big endian bytes. Every update is a
+// transaction, so a batch of counter increments is applied entirely or
+// not at all.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewStorage opens or creates the file, it fails if another process holds
+// the file for longer than a second.
+func NewStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(counterBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(gaugeBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func (s *BoltStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	if err := ctx.Err(); err != nil {
+		return metric, err
+	}
+
+	name := bucketName(metric.MType)
+	if name == nil {
+		return metric, ErrUnknownMetric
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(name).Get([]byte(metric.ID))
+		if value == nil {
+			return ErrUnknownMetric
+		}
+		setValue(&metric, value)
+		return nil
+	})
+	return metric, err
+}
+
+func (s *BoltStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]models.MetricsWithValue, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, mtype := range []string{"counter", "gauge"} {
+			err := tx.Bucket(bucketName(mtype)).ForEach(func(k, v []byte) error {
+				metric := models.MetricsWithValue{ID: string(k), MType: mtype}
+				setValue(&metric, v)
+				list = append(list, metric)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+func (s *BoltStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	return s.UpdateList(ctx, []models.MetricsWithValue{metric})
+}
+
+// UpdateList adds counters to stored values and replaces gauges in one
+// transaction.
+func (s *BoltStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	return s.update(ctx, list, true)
+}
+
+func (s *BoltStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	return s.update(ctx, list, false)
+}
+
+func (s *BoltStorage) update(ctx context.Context, list []models.MetricsWithValue, addCounters bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, metric := range list {
+			name := bucketName(metric.MType)
+			if name == nil {
+				continue
+			}
+			bucket := tx.Bucket(name)
+
+			key := []byte(metric.ID)
+			if metric.MType == "counter" && addCounters {
+				if stored := bucket.Get(key); stored != nil {
+					metric.Delta += int64(binary.BigEndian.Uint64(stored))
+				}
+			}
+			if err := bucket.Put(key, encodeValue(metric)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) Ping() error {
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStorage) SetRetryCount(attempts int) {}
+
+func (s *BoltStorage) SetRetryStartWaitTime(sleep time.Duration) {}
+
+func (s *BoltStorage) SetRetryIncreaseWaitTime(delta time.Duration) {}
+
+func bucketName(mtype string) []byte {
+	switch mtype {
+	case "counter":
+		return counterBucket
+	case "gauge":
+		return gaugeBucket
+	}
+	return nil
+}
+
+func encodeValue(metric models.MetricsWithValue) []byte {
+	value := make([]byte, 8)
+	if metric.MType == "counter" {
+		binary.BigEndian.PutUint64(value, uint64(metric.Delta))
+	} else {
+		binary.BigEndian.PutUint64(value, math.Float64bits(metric.Value))
+	}
+	return value
+}
+
+func setValue(metric *models.MetricsWithValue, value []byte) {
+	bits := binary.BigEndian.Uint64(value)
+	if metric.MType == "counter" {
+		metric.Delta = int64(bits)
+	} else {
+		metric.Value = math.Float64frombits(bits)
+	}
+}
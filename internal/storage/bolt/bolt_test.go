@@ -0,0 +1,143 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/storagetest"
+)
+
+func sortList(list []models.MetricsWithValue) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MType != list[j].MType {
+			return list[i].MType < list[j].MType
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+func TestBoltStorage_UpdateList(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]models.MetricsWithValue
+		changed []models.MetricsWithValue
+		want    []models.MetricsWithValue
+	}{
+		{
+			name: "counters add up within and across batches",
+			batches: [][]models.MetricsWithValue{
+				{{ID: "c", MType: "counter", Delta: 1}, {ID: "c", MType: "counter", Delta: 2}},
+				{{ID: "c", MType: "counter", Delta: 4}},
+			},
+			want: []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: 7}},
+		},
+		{
+			name: "gauges are replaced",
+			batches: [][]models.MetricsWithValue{
+				{{ID: "g", MType: "gauge", Value: 1.5}},
+				{{ID: "g", MType: "gauge", Value: -2.25}},
+			},
+			want: []models.MetricsWithValue{{ID: "g", MType: "gauge", Value: -2.25}},
+		},
+		{
+			name: "changed values are stored as they are",
+			batches: [][]models.MetricsWithValue{
+				{{ID: "c", MType: "counter", Delta: 10}, {ID: "g", MType: "gauge", Value: 1}},
+			},
+			changed: []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: 3}},
+			want: []models.MetricsWithValue{
+				{ID: "c", MType: "counter", Delta: 3},
+				{ID: "g", MType: "gauge", Value: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.db")
+			s, err := NewStorage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			for _, batch := range tt.batches {
+				if err := s.UpdateList(ctx, batch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.changed != nil {
+				if err := s.UpdateChanged(ctx, tt.changed); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Values have to survive reopening.
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s, err = NewStorage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			got, err := s.GetList(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sortList(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetList() = %v, want %v", got, tt.want)
+			}
+
+			for _, want := range tt.want {
+				metric, err := s.Get(ctx, models.MetricsWithValue{ID: want.ID, MType: want.MType})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if metric != want {
+					t.Errorf("Get() = %v, want %v", metric, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBoltStorage_Get(t *testing.T) {
+	s, err := NewStorage(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.Get(ctx, models.MetricsWithValue{ID: "unknown", MType: "counter"}); !errors.Is(err, ErrUnknownMetric) {
+		t.Errorf("Get() of unknown metric error = %v, want %v", err, ErrUnknownMetric)
+	}
+	if _, err := s.Get(ctx, models.MetricsWithValue{ID: "c", MType: "histogram"}); !errors.Is(err, ErrUnknownMetric) {
+		t.Errorf("Get() of unknown type error = %v, want %v", err, ErrUnknownMetric)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := s.Update(canceled, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Update() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestBoltStorage_conformance(t *testing.T) {
+	storagetest.Main(t, func(t *testing.T) storagetest.MainStorage {
+		s, err := NewStorage(filepath.Join(t.TempDir(), "metrics.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
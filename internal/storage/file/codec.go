@@ -0,0 +1,215 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+var (
+	ErrUnknownCompression = errors.New("unknown backup compression")
+	ErrMissingKey         = errors.New("backup is encrypted but no key is configured")
+	ErrDecrypt            = errors.New("backup cannot be decrypted with configured key")
+)
+
+var (
+	encryptedMagic = []byte("OBSAES1\n")
+	gzipMagic      = []byte{0x1f, 0x8b}
+	zstdMagic      = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Codec turns serialized backup into bytes stored on disk: it compresses
+// the data first and then encrypts it with AES-GCM. Decoding detects the
+// format by magic bytes, so files written with other settings, including
+// plain ones, are still readable.
+type Codec struct {
+	compression Compression
+	aead        cipher.AEAD
+}
+
+func NewCodec(compression Compression, key []byte) (Codec, error) {
+	var c Codec
+
+	switch compression {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		c.compression = compression
+	default:
+		return c, ErrUnknownCompression
+	}
+
+	if len(key) == 0 {
+		return c, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return c, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return c, err
+	}
+	c.aead = aead
+	return c, nil
+}
+
+// LoadKey returns hex decoded key given directly or read from keyFile.
+// It returns nil if neither is set.
+func LoadKey(key string, keyFile string) ([]byte, error) {
+	if key == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(data)
+	}
+
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(key)
+}
+
+func (c Codec) encode(data []byte) ([]byte, error) {
+	data, err := c.compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if c.aead == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedMagic)+len(nonce)+len(data)+c.aead.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, data, encryptedMagic), nil
+}
+
+func (c Codec) decode(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, encryptedMagic) {
+		if c.aead == nil {
+			return nil, ErrMissingKey
+		}
+
+		data = data[len(encryptedMagic):]
+		if len(data) < c.aead.NonceSize() {
+			return nil, ErrDecrypt
+		}
+		nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+
+		var err error
+		data, err = c.aead.Open(nil, nonce, ciphertext, encryptedMagic)
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case bytes.HasPrefix(data, zstdMagic):
+		d, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		return d.DecodeAll(data, nil)
+	}
+	return data, nil
+}
+
+// encodeLine encrypts a single line of the delta file. Compression is not
+// applied to lines, they are too short to benefit from it.
+func (c Codec) encodeLine(line []byte) ([]byte, error) {
+	if c.aead == nil {
+		return line, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := c.aead.Seal(nonce, nonce, line, encryptedMagic)
+
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+func (c Codec) decodeLine(line []byte) ([]byte, error) {
+	// Plain lines are JSON, base64 of encrypted ones never starts with a
+	// bracket.
+	if bytes.HasPrefix(line, []byte("[")) || bytes.HasPrefix(line, []byte("{")) {
+		return line, nil
+	}
+	if c.aead == nil {
+		return nil, ErrMissingKey
+	}
+
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	sealed = sealed[:n]
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	line, err = c.aead.Open(nil, sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():], encryptedMagic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return line, nil
+}
+
+func (c Codec) compress(data []byte) ([]byte, error) {
+	switch c.compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		e, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer e.Close()
+		return e.EncodeAll(data, nil), nil
+	}
+	return data, nil
+}
@@ -0,0 +1,189 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+func TestFileStorage_Codec(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	list := []models.MetricsWithValue{
+		{ID: "testCounter", MType: "counter", Delta: 5},
+		{ID: "testGauge", MType: "gauge", Value: 1.5},
+	}
+
+	tests := []struct {
+		name        string
+		compression Compression
+		key         []byte
+		plaintext   bool
+	}{
+		{
+			name:        "no compression no encryption",
+			compression: CompressionNone,
+			plaintext:   true,
+		},
+		{
+			name:        "gzip",
+			compression: CompressionGzip,
+		},
+		{
+			name:        "zstd",
+			compression: CompressionZstd,
+		},
+		{
+			name:        "encryption",
+			compression: CompressionNone,
+			key:         key,
+		},
+		{
+			name:        "zstd with encryption",
+			compression: CompressionZstd,
+			key:         key,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "backup.json")
+
+			codec, err := NewCodec(tt.compression, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs := NewStorage(filename)
+			fs.SetCodec(codec)
+
+			if err := fs.UpdateList(context.Background(), list); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0600 {
+				t.Errorf("backup permissions = %o, want 600", perm)
+			}
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bytes.Contains(data, []byte("testCounter")); got != tt.plaintext {
+				t.Errorf("backup contains plain metric names = %v, want %v", got, tt.plaintext)
+			}
+
+			// Reading side needs only the key, format is detected.
+			reader, err := NewCodec(CompressionNone, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs = NewStorage(filename)
+			fs.SetCodec(reader)
+
+			got, err := fs.GetList(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, list) {
+				t.Errorf("GetList() = %v, want %v", got, list)
+			}
+		})
+	}
+}
+
+func TestFileStorage_CodecKeyErrors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "backup.json")
+
+	codec, err := NewCodec(CompressionGzip, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewStorage(filename)
+	fs.SetCodec(codec)
+	if err := fs.UpdateList(context.Background(), []models.MetricsWithValue{{ID: "a", MType: "counter", Delta: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong, err := NewCodec(CompressionNone, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		codec Codec
+		want  error
+	}{
+		{
+			name: "no key",
+			want: ErrMissingKey,
+		},
+		{
+			name:  "wrong key",
+			codec: wrong,
+			want:  ErrDecrypt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := NewStorage(filename)
+			fs.SetCodec(tt.codec)
+
+			if _, err := fs.GetList(context.Background()); !errors.Is(err, tt.want) {
+				t.Errorf("GetList() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+	tests := []struct {
+		name    string
+		key     string
+		keyFile string
+		want    []byte
+	}{
+		{
+			name: "no key",
+		},
+		{
+			name: "key",
+			key:  "000102030405060708090a0b0c0d0e0f",
+			want: want,
+		},
+		{
+			name:    "key file",
+			keyFile: keyFile,
+			want:    want,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadKey(tt.key, tt.keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/retry"
+)
+
+// emptyBase identifies a missing backup file, deltas may be written on top
+// of it as well.
+var emptyBase = hex.EncodeToString(sha256.New().Sum(nil))
+
+type deltaHeader struct {
+	Base string `json:"base"`
+}
+
+// deltaRecord is a line of the delta file written with a checkpoint, lines
+// written without it are plain lists of metrics.
+type deltaRecord struct {
+	WALSeq  int              `json:"wal_seq"`
+	Metrics []models.Metrics `json:"metrics"`
+}
+
+func (fs *FileStorage) SetCompactAfter(records int) {
+	fs.compactAfter = records
+}
+
+// UpdateChanged appends current values of changed series to the delta file
+// <filename>.delta. The delta file is bound to the backup it was written
+// against and is folded into a new backup once it holds compactAfter
+// records or a snapshot is due: snapshots are taken of full backups only.
+func (fs *FileStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	return retry.Do(ctx, fs.policy, func(ctx context.Context) error {
+		return fs.updateChanged(ctx, list)
+	})
+}
+
+func (fs *FileStorage) updateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.base == "" {
+		if _, _, err := fs.load(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.appendDelta(list); err != nil {
+		return err
+	}
+
+	if fs.compactAfter > 0 && fs.deltaCount >= fs.compactAfter || fs.snapshotDue(time.Now()) {
+		merged, _, err := fs.load(ctx)
+		if err != nil {
+			return err
+		}
+		return fs.writeBase(ctx, merged)
+	}
+	return nil
+}
+
+func (fs *FileStorage) deltaName() string {
+	return fs.filename + ".delta"
+}
+
+func (fs *FileStorage) appendDelta(list []models.MetricsWithValue) error {
+	metrics := make([]models.Metrics, 0, len(list))
+	for _, metric := range list {
+		metrics = append(metrics, models.ToMetric(metric))
+	}
+
+	var record any = metrics
+	if fs.checkpoint > 0 {
+		record = deltaRecord{WALSeq: fs.checkpoint, Metrics: metrics}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line, err = fs.codec.encodeLine(line)
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	if !fs.deltaOpen {
+		h, err := json.Marshal(deltaHeader{Base: fs.base})
+		if err != nil {
+			return err
+		}
+		buf = append(h, '\n')
+		fs.deltaSize = 0
+	}
+	buf = append(buf, line...)
+	buf = append(buf, '\n')
+
+	file, err := os.OpenFile(fs.deltaName(), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Anything past the last complete record is a torn or failed write.
+	if err := file.Truncate(fs.deltaSize); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(buf, fs.deltaSize); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if !fs.deltaOpen {
+		dir, _ := splitDir(fs.filename)
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+
+	fs.deltaOpen = true
+	fs.deltaSize += int64(len(buf))
+	fs.deltaCount += len(list)
+	return nil
+}
+
+func (fs *FileStorage) readDelta() ([]models.MetricsWithValue, int, error) {
+	fs.deltaOpen = false
+	fs.deltaSize = 0
+	fs.deltaCount = 0
+
+	file, err := os.Open(fs.deltaName())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var h deltaHeader
+	if err := json.Unmarshal(line, &h); err != nil {
+		return nil, 0, err
+	}
+	// Delta left from a backup that has been replaced since then.
+	if h.Base != fs.base {
+		return nil, 0, nil
+	}
+	size := int64(len(line))
+
+	list := make([]models.MetricsWithValue, 0)
+	seq := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(line))
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		line, err = fs.codec.decodeLine(line)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var record deltaRecord
+		if bytes.HasPrefix(line, []byte("{")) {
+			err = json.Unmarshal(line, &record)
+		} else {
+			err = json.Unmarshal(line, &record.Metrics)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if record.WALSeq > seq {
+			seq = record.WALSeq
+		}
+		for _, metric := range record.Metrics {
+			list = append(list, models.ToMetricWithValue(metric))
+		}
+	}
+
+	fs.deltaOpen = true
+	fs.deltaSize = size
+	fs.deltaCount = len(list)
+	return list, seq, nil
+}
+
+func (fs *FileStorage) removeDelta() error {
+	fs.deltaOpen = false
+	fs.deltaSize = 0
+	fs.deltaCount = 0
+
+	if err := os.Remove(fs.deltaName()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// merge overrides values in list with newer absolute values from changed.
+func merge(list []models.MetricsWithValue, changed []models.MetricsWithValue) []models.MetricsWithValue {
+	if len(changed) == 0 {
+		return list
+	}
+
+	index := make(map[[2]string]int, len(list))
+	for i, metric := range list {
+		index[[2]string{metric.MType, metric.ID}] = i
+	}
+
+	for _, metric := range changed {
+		key := [2]string{metric.MType, metric.ID}
+		if i, ok := index[key]; ok {
+			list[i] = metric
+			continue
+		}
+		index[key] = len(list)
+		list = append(list, metric)
+	}
+	return list
+}
@@ -0,0 +1,219 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+func sortList(list []models.MetricsWithValue) []models.MetricsWithValue {
+	sort.Slice(list, func(i, j int) bool { return list[i].MType+list[i].ID < list[j].MType+list[j].ID })
+	return list
+}
+
+func TestFileStorage_UpdateChanged(t *testing.T) {
+	ctx := context.Background()
+
+	base := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 1},
+		{ID: "g", MType: "gauge", Value: 1},
+	}
+	changed := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 5},
+		{ID: "n", MType: "gauge", Value: 2},
+	}
+	want := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 5},
+		{ID: "g", MType: "gauge", Value: 1},
+		{ID: "n", MType: "gauge", Value: 2},
+	}
+
+	tests := []struct {
+		name         string
+		key          []byte
+		compactAfter int
+		wantDelta    bool
+	}{
+		{
+			name:         "delta appended",
+			compactAfter: 10,
+			wantDelta:    true,
+		},
+		{
+			name:         "encrypted delta appended",
+			key:          bytes.Repeat([]byte{1}, 32),
+			compactAfter: 10,
+			wantDelta:    true,
+		},
+		{
+			name:         "delta compacted",
+			compactAfter: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "backup.json")
+			codec, err := NewCodec(CompressionNone, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fs := NewStorage(filename)
+			fs.SetCodec(codec)
+			fs.SetCompactAfter(tt.compactAfter)
+			if err := fs.UpdateList(ctx, base); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.UpdateChanged(ctx, changed); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = os.Stat(filename + ".delta")
+			if gotDelta := err == nil; gotDelta != tt.wantDelta {
+				t.Errorf("delta file exists = %v, want %v", gotDelta, tt.wantDelta)
+			}
+
+			fs = NewStorage(filename)
+			fs.SetCodec(codec)
+			got, err := fs.GetList(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sortList(got), want) {
+				t.Errorf("GetList() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileStorage_UpdateChanged_staleDelta(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "backup.json")
+
+	fs := NewStorage(filename)
+	if err := fs.UpdateList(ctx, []models.MetricsWithValue{{ID: "g", MType: "gauge", Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.UpdateChanged(ctx, []models.MetricsWithValue{{ID: "g", MType: "gauge", Value: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	delta, err := os.ReadFile(filename + ".delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after the new backup is written but before the delta is removed.
+	want := []models.MetricsWithValue{{ID: "g", MType: "gauge", Value: 3}}
+	if err := fs.UpdateList(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename+".delta", delta, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewStorage(filename).GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() = %v, want %v", got, want)
+	}
+}
+
+func TestFileStorage_UpdateChanged_tornTail(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "backup.json")
+
+	fs := NewStorage(filename)
+	if err := fs.UpdateChanged(ctx, []models.MetricsWithValue{{ID: "a", MType: "gauge", Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(filename+".delta", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`[{"id":"torn","type":"gauge"`)
+	f.Close()
+
+	fs = NewStorage(filename)
+	if err := fs.UpdateChanged(ctx, []models.MetricsWithValue{{ID: "b", MType: "gauge", Value: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewStorage(filename).GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.MetricsWithValue{
+		{ID: "a", MType: "gauge", Value: 1},
+		{ID: "b", MType: "gauge", Value: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() = %v, want %v", got, want)
+	}
+}
+
+func TestFileStorage_UpdateChanged_missingKey(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "backup.json")
+
+	codec, err := NewCodec(CompressionNone, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewStorage(filename)
+	fs.SetCodec(codec)
+	if err := fs.UpdateChanged(ctx, []models.MetricsWithValue{{ID: "a", MType: "gauge", Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStorage(filename).GetList(ctx); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("GetList() error = %v, want %v", err, ErrMissingKey)
+	}
+}
+
+func TestFileStorage_UpdateChanged_concurrentGetList(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "backup.json")
+
+	fs := NewStorage(filename)
+	fs.SetCompactAfter(10)
+
+	// GetList reads the delta file while flushes append to it and compact
+	// it, it must neither see a broken chain nor break it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if _, err := fs.GetList(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		metric := models.MetricsWithValue{ID: "g", MType: "gauge", Value: float64(i)}
+		if err := fs.UpdateChanged(ctx, []models.MetricsWithValue{metric}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	got, err := NewStorage(filename).GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []models.MetricsWithValue{{ID: "g", MType: "gauge", Value: 49}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() = %v, want %v", got, want)
+	}
+}
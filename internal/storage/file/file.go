@@ -1,33 +1,136 @@
 package file
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"hash"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/retry"
 )
 
+// FileStorage keeps state of the backup and its delta file between calls,
+// mu is held for the whole of every call that reads or writes them.
 type FileStorage struct {
-	filename string
+	filename     string
+	codec        Codec
+	retention    Retention
+	lastSnapshot time.Time
+	policy       retry.Policy
+	checkpoint   int
+	mu           sync.Mutex
+
+	base         string
+	deltaOpen    bool
+	deltaSize    int64
+	deltaCount   int
+	compactAfter int
 }
 
 func NewStorage(filename string) *FileStorage {
 	return &FileStorage{
-		filename: filename,
+		filename:     filename,
+		compactAfter: 10000,
+		policy:       retry.Policy{Retriable: transient},
 	}
 }
 
+// transient reports errors that may go away by themselves, such as file
+// locked by antivirus or backup software for a moment.
+func transient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EBUSY)
+}
+
+func (fs *FileStorage) SetCodec(codec Codec) {
+	fs.codec = codec
+}
+
 func (fs *FileStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	return retry.DoValue(ctx, fs.policy, fs.getList)
+}
+
+func (fs *FileStorage) getList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	list, _, err := fs.load(ctx)
+	return list, err
+}
+
+// SetCheckpoint sets sequence number of the last write-ahead log segment
+// contained in the backup, it is stored with the following writes.
+func (fs *FileStorage) SetCheckpoint(seq int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.checkpoint = seq
+}
+
+// Checkpoint returns sequence number of the last write-ahead log segment
+// contained in the stored backup, 0 if there is none.
+func (fs *FileStorage) Checkpoint(ctx context.Context) (int, error) {
+	return retry.DoValue(ctx, fs.policy, func(ctx context.Context) (int, error) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+
+		_, seq, err := fs.load(ctx)
+		return seq, err
+	})
+}
+
+// load reads the backup with its delta and the checkpoint they were
+// written with.
+func (fs *FileStorage) load(ctx context.Context) ([]models.MetricsWithValue, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	list, seq, err := fs.readBase()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	changed, deltaSeq, err := fs.readDelta()
+	if err != nil {
+		return nil, 0, err
+	}
+	if deltaSeq > seq {
+		seq = deltaSeq
+	}
+	return merge(list, changed), seq, nil
+}
+
+func (fs *FileStorage) readBase() ([]models.MetricsWithValue, int, error) {
 	list := make([]models.MetricsWithValue, 0)
 
-	consumer, err := newConsumer(fs.filename)
+	data, err := os.ReadFile(fs.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		fs.base = emptyBase
+		return list, 0, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	data, err = fs.codec.decode(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	consumer, err := newConsumer(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
 	}
-	defer consumer.close()
 
 	for {
 		metric, err := consumer.readMetric()
@@ -35,20 +138,39 @@ func (fs *FileStorage) GetList(ctx context.Context) ([]models.MetricsWithValue,
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			return nil, 0, err
 		}
 		metricWithValue := models.ToMetricWithValue(*metric)
 		list = append(list, metricWithValue)
 	}
-	return list, nil
+
+	if err := consumer.validate(); err != nil {
+		return nil, 0, err
+	}
+	fs.base = consumer.checksum()
+	return list, consumer.walSeq(), nil
 }
 
 func (fs *FileStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
-	producer, err := newProducer(fs.filename)
-	if err != nil {
+	return retry.Do(ctx, fs.policy, func(ctx context.Context) error {
+		return fs.updateList(ctx, list)
+	})
+}
+
+func (fs *FileStorage) updateList(ctx context.Context, list []models.MetricsWithValue) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.writeBase(ctx, list)
+}
+
+func (fs *FileStorage) writeBase(ctx context.Context, list []models.MetricsWithValue) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	defer producer.close()
+
+	producer := newProducer()
+	producer.walSeq = fs.checkpoint
 
 	for _, metric := range list {
 		m := models.ToMetric(metric)
@@ -56,67 +178,241 @@ func (fs *FileStorage) UpdateList(ctx context.Context, list []models.MetricsWith
 			return err
 		}
 	}
-	return nil
+
+	data, err := producer.bytes()
+	if err != nil {
+		return err
+	}
+
+	data, err = fs.codec.encode(data)
+	if err != nil {
+		return err
+	}
+
+	// The delta file is bound to the backup by checksum and is ignored once
+	// the backup is replaced. If the content doesn't change, the delta has
+	// to go first, a crash in between then leaves the same state either way.
+	checksum := producer.checksum()
+	if checksum == fs.base {
+		if err := fs.removeDelta(); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFileAtomic(fs.filename, data); err != nil {
+		return err
+	}
+	fs.base = checksum
+
+	if err := fs.removeDelta(); err != nil {
+		return err
+	}
+	return fs.snapshot(data, time.Now())
 }
 
 func (fs *FileStorage) Ping() error { return nil }
 
-func (fs *FileStorage) SetRetryCount(attempts int) {}
+func (fs *FileStorage) Close() error { return nil }
 
-func (fs *FileStorage) SetRetryStartWaitTime(sleep time.Duration) {}
+func (fs *FileStorage) SetRetryCount(attempts int) {
+	fs.policy.Attempts = attempts
+}
 
-func (fs *FileStorage) SetRetryIncreaseWaitTime(delta time.Duration) {}
+func (fs *FileStorage) SetRetryStartWaitTime(sleep time.Duration) {
+	fs.policy.Wait = sleep
+}
+
+func (fs *FileStorage) SetRetryIncreaseWaitTime(delta time.Duration) {
+	fs.policy.Increase = delta
+}
+
+const formatVersion = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported backup format version")
+	ErrCountMismatch      = errors.New("backup record count does not match header")
+	ErrChecksumMismatch   = errors.New("backup checksum does not match header")
+)
+
+type header struct {
+	Version  int    `json:"version"`
+	Count    int    `json:"count"`
+	Checksum string `json:"checksum"`
+	WALSeq   int    `json:"wal_seq,omitempty"`
+}
 
 type consumer struct {
-	file    *os.File
-	decoder *json.Decoder
+	reader *bufio.Reader
+	header *header
+	first  []byte
+	hash   hash.Hash
+	count  int
 }
 
-func newConsumer(filename string) (*consumer, error) {
-	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
+func newConsumer(r io.Reader) (*consumer, error) {
+	c := &consumer{
+		reader: bufio.NewReader(r),
+		hash:   sha256.New(),
+	}
+
+	line, err := c.readLine()
+	if err != nil && err != io.EOF {
 		return nil, err
 	}
 
-	return &consumer{
-		file:    file,
-		decoder: json.NewDecoder(file),
-	}, nil
+	var h header
+	if json.Unmarshal(line, &h) == nil && h.Version != 0 {
+		if h.Version != formatVersion {
+			return nil, ErrUnsupportedVersion
+		}
+		c.header = &h
+		return c, nil
+	}
+
+	// Backups written before the header was introduced start right
+	// with the first record.
+	c.first = line
+	return c, nil
+}
+
+func (c *consumer) readLine() ([]byte, error) {
+	line, err := c.reader.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return line, err
 }
 
 func (c *consumer) readMetric() (*models.Metrics, error) {
+	line := c.first
+	c.first = nil
+
+	for len(bytes.TrimSpace(line)) == 0 {
+		var err error
+		line, err = c.readLine()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.hash.Write(line)
+	c.count++
+
 	var metric models.Metrics
-	if err := c.decoder.Decode(&metric); err != nil {
+	if err := json.Unmarshal(line, &metric); err != nil {
 		return nil, err
 	}
 	return &metric, nil
 }
 
-func (c *consumer) close() error {
-	return c.file.Close()
+func (c *consumer) validate() error {
+	if c.header == nil {
+		return nil
+	}
+	if c.count != c.header.Count {
+		return ErrCountMismatch
+	}
+	if hex.EncodeToString(c.hash.Sum(nil)) != c.header.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (c *consumer) checksum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+func (c *consumer) walSeq() int {
+	if c.header == nil {
+		return 0
+	}
+	return c.header.WALSeq
 }
 
 type producer struct {
-	file    *os.File
+	records bytes.Buffer
 	encoder *json.Encoder
+	count   int
+	walSeq  int
 }
 
-func newProducer(filename string) (*producer, error) {
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0666)
+func newProducer() *producer {
+	p := &producer{}
+	p.encoder = json.NewEncoder(&p.records)
+	return p
+}
+
+func (p *producer) writeMetric(metric *models.Metrics) error {
+	if err := p.encoder.Encode(&metric); err != nil {
+		return err
+	}
+	p.count++
+	return nil
+}
+
+func (p *producer) checksum() string {
+	checksum := sha256.Sum256(p.records.Bytes())
+	return hex.EncodeToString(checksum[:])
+}
+
+func (p *producer) bytes() ([]byte, error) {
+	h, err := json.Marshal(header{
+		Version:  formatVersion,
+		Count:    p.count,
+		Checksum: p.checksum(),
+		WALSeq:   p.walSeq,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &producer{
-		file:    file,
-		encoder: json.NewEncoder(file),
-	}, nil
+	data := make([]byte, 0, len(h)+1+p.records.Len())
+	data = append(data, h...)
+	data = append(data, '\n')
+	data = append(data, p.records.Bytes()...)
+	return data, nil
 }
 
-func (p *producer) writeMetric(metric *models.Metrics) error {
-	return p.encoder.Encode(&metric)
+func writeFileAtomic(filename string, data []byte) error {
+	dir, base := splitDir(filename)
+
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func splitDir(filename string) (string, string) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	return dir, base
 }
 
-func (p *producer) close() error {
-	return p.file.Close()
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
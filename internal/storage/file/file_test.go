@@ -2,13 +2,17 @@ package file
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/storagetest"
 )
 
 func TestFileStorage_GetList(t *testing.T) {
@@ -68,7 +72,8 @@ func TestFileStorage_UpdateList(t *testing.T) {
 	}{
 		{
 			name: "list updated",
-			want: `{"id":"testCounter","type":"counter","delta":1}`,
+			want: `{"version":1,"count":1,"checksum":"836b1b26203275ae981858413186671825e312ac4335cf1b8a5f26f84ea5f255"}` + "\n" +
+				`{"id":"testCounter","type":"counter","delta":1}`,
 		},
 	}
 
@@ -98,3 +103,109 @@ func TestFileStorage_UpdateList(t *testing.T) {
 		})
 	}
 }
+
+func TestFileStorage_UpdateList_shorterSnapshot(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+	fs := NewStorage(filename)
+	ctx := context.Background()
+
+	long := []models.MetricsWithValue{
+		{ID: "first", MType: "counter", Delta: 1},
+		{ID: "second", MType: "gauge", Value: 2},
+		{ID: "third", MType: "gauge", Value: 3},
+	}
+	short := long[:1]
+
+	if err := fs.UpdateList(ctx, long); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.UpdateList(ctx, short); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, short) {
+		t.Errorf("GetList() = %v, want %v", got, short)
+	}
+
+	matches, _ := filepath.Glob(filename + ".tmp-*")
+	if len(matches) != 0 {
+		t.Errorf("UpdateList() left temporary files %v", matches)
+	}
+}
+
+func TestFileStorage_GetList_validation(t *testing.T) {
+	record := `{"id":"testCounter","type":"counter","delta":1}` + "\n"
+	checksum := "836b1b26203275ae981858413186671825e312ac4335cf1b8a5f26f84ea5f255"
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []models.MetricsWithValue
+		wantErr error
+	}{
+		{
+			name: "valid snapshot",
+			data: `{"version":1,"count":1,"checksum":"` + checksum + `"}` + "\n" + record,
+			want: []models.MetricsWithValue{{ID: "testCounter", MType: "counter", Delta: 1}},
+		},
+		{
+			name:    "truncated snapshot",
+			data:    `{"version":1,"count":2,"checksum":"` + checksum + `"}` + "\n" + record,
+			wantErr: ErrCountMismatch,
+		},
+		{
+			name:    "corrupted record",
+			data:    `{"version":1,"count":1,"checksum":"` + checksum + `"}` + "\n" + strings.Replace(record, "1", "7", 1),
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "future version",
+			data:    `{"version":99,"count":1,"checksum":"` + checksum + `"}` + "\n" + record,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name: "empty file",
+			data: "",
+			want: []models.MetricsWithValue{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "metrics-db.json")
+			if err := os.WriteFile(filename, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := NewStorage(filename).GetList(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetList() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStorage_GetList_missingFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	got, err := NewStorage(filename).GetList(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("GetList() = %v, want empty list", got)
+	}
+}
+
+func TestFileStorage_conformance(t *testing.T) {
+	storagetest.Backup(t, func(t *testing.T) storagetest.BackupStorage {
+		return NewStorage(filepath.Join(t.TempDir(), "metrics-db.json"))
+	})
+}
@@ -0,0 +1,169 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+type Retention struct {
+	Interval   time.Duration
+	KeepLast   int
+	KeepHourly int
+	KeepDaily  int
+}
+
+func (r Retention) enabled() bool {
+	return r.KeepLast > 0 || r.KeepHourly > 0 || r.KeepDaily > 0
+}
+
+func (fs *FileStorage) SetRetention(retention Retention) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.retention = retention
+}
+
+func (fs *FileStorage) ListSnapshots() ([]models.Snapshot, error) {
+	prefix := filepath.Base(fs.filename) + ".snapshot-"
+	matches, err := filepath.Glob(fs.filename + ".snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]models.Snapshot, 0, len(matches))
+	for _, match := range matches {
+		name := filepath.Base(match)
+		created, err := time.Parse(snapshotTimeFormat, strings.TrimPrefix(name, prefix))
+		if err != nil {
+			continue
+		}
+
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+
+		snapshots = append(snapshots, models.Snapshot{
+			Name:    name,
+			Created: created,
+			Size:    info.Size(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.After(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+func (fs *FileStorage) SnapshotPath(name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(name) {
+		if filepath.Base(name) != name {
+			return "", ErrSnapshotNotFound
+		}
+		path = filepath.Join(filepath.Dir(fs.filename), name)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", ErrSnapshotNotFound
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// OpenSnapshot returns storage reading snapshot name with the same codec.
+func (fs *FileStorage) OpenSnapshot(name string) (*FileStorage, error) {
+	path, err := fs.SnapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := NewStorage(path)
+	snapshot.SetCodec(fs.codec)
+	snapshot.policy = fs.policy
+	return snapshot, nil
+}
+
+// snapshotDue reports whether a backup written at now is kept as a
+// snapshot, it is called with fs.mu held.
+func (fs *FileStorage) snapshotDue(now time.Time) bool {
+	return fs.retention.enabled() && now.Sub(fs.lastSnapshot) >= fs.retention.Interval
+}
+
+// snapshot is called with fs.mu held.
+func (fs *FileStorage) snapshot(data []byte, now time.Time) error {
+	if !fs.snapshotDue(now) {
+		return nil
+	}
+
+	name := fs.filename + ".snapshot-" + now.UTC().Format(snapshotTimeFormat)
+	if err := writeFileAtomic(name, data); err != nil {
+		return err
+	}
+	fs.lastSnapshot = now
+
+	return fs.prune()
+}
+
+func (fs *FileStorage) prune() error {
+	snapshots, err := fs.ListSnapshots()
+	if err != nil {
+		return err
+	}
+
+	keep := retained(snapshots, fs.retention)
+	dir := filepath.Dir(fs.filename)
+
+	var errs []error
+	for _, snapshot := range snapshots {
+		if keep[snapshot.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, snapshot.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retained picks snapshots to keep from the list sorted newest first: the
+// latest KeepLast ones plus the newest snapshot of each of the latest
+// KeepHourly hours and KeepDaily days.
+func retained(snapshots []models.Snapshot, retention Retention) map[string]bool {
+	keep := make(map[string]bool)
+
+	for i := 0; i < len(snapshots) && i < retention.KeepLast; i++ {
+		keep[snapshots[i].Name] = true
+	}
+
+	keepNewestPerPeriod := func(period time.Duration, count int) {
+		seen := make(map[time.Time]bool)
+		for _, snapshot := range snapshots {
+			if len(seen) >= count {
+				return
+			}
+			bucket := snapshot.Created.UTC().Truncate(period)
+			if seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+			keep[snapshot.Name] = true
+		}
+	}
+	keepNewestPerPeriod(time.Hour, retention.KeepHourly)
+	keepNewestPerPeriod(24*time.Hour, retention.KeepDaily)
+
+	return keep
+}
@@ -0,0 +1,190 @@
+package file
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+func TestRetained(t *testing.T) {
+	base := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) models.Snapshot {
+		created := base.Add(-d)
+		return models.Snapshot{Name: created.Format(snapshotTimeFormat), Created: created}
+	}
+
+	snapshots := []models.Snapshot{
+		at(10 * time.Minute),
+		at(20 * time.Minute),
+		at(70 * time.Minute),
+		at(80 * time.Minute),
+		at(26 * time.Hour),
+		at(50 * time.Hour),
+	}
+
+	tests := []struct {
+		name      string
+		retention Retention
+		want      []models.Snapshot
+	}{
+		{
+			name:      "keep last",
+			retention: Retention{KeepLast: 2},
+			want:      []models.Snapshot{snapshots[0], snapshots[1]},
+		},
+		{
+			name:      "keep hourly",
+			retention: Retention{KeepHourly: 2},
+			want:      []models.Snapshot{snapshots[0], snapshots[2]},
+		},
+		{
+			name:      "keep daily",
+			retention: Retention{KeepDaily: 3},
+			want:      []models.Snapshot{snapshots[0], snapshots[4], snapshots[5]},
+		},
+		{
+			name:      "combined",
+			retention: Retention{KeepLast: 1, KeepHourly: 2, KeepDaily: 2},
+			want:      []models.Snapshot{snapshots[0], snapshots[2], snapshots[4]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := retained(snapshots, tt.retention)
+
+			got := make([]models.Snapshot, 0)
+			for _, snapshot := range snapshots {
+				if keep[snapshot.Name] {
+					got = append(got, snapshot)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("retained() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStorage_snapshots(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+	fs := NewStorage(filename)
+	fs.SetRetention(Retention{KeepLast: 2})
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		if err := fs.UpdateList(ctx, []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := fs.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("ListSnapshots() returned %d snapshots, want 2", len(snapshots))
+	}
+	if !sort.SliceIsSorted(snapshots, func(i, j int) bool { return snapshots[i].Created.After(snapshots[j].Created) }) {
+		t.Errorf("ListSnapshots() is not sorted newest first")
+	}
+
+	path, err := fs.SnapshotPath(snapshots[1].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewStorage(path).GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() of older snapshot = %v, want %v", got, want)
+	}
+
+	if _, err := fs.SnapshotPath("../" + snapshots[1].Name); err != ErrSnapshotNotFound {
+		t.Errorf("SnapshotPath() error = %v, want %v", err, ErrSnapshotNotFound)
+	}
+	if _, err := fs.SnapshotPath("missing"); err != ErrSnapshotNotFound {
+		t.Errorf("SnapshotPath() error = %v, want %v", err, ErrSnapshotNotFound)
+	}
+}
+
+func TestFileStorage_snapshotInterval(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+	fs := NewStorage(filename)
+	fs.SetRetention(Retention{Interval: time.Hour, KeepLast: 10})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := fs.UpdateList(ctx, []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := fs.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Errorf("ListSnapshots() returned %d snapshots, want 1", len(snapshots))
+	}
+}
+
+func TestFileStorage_snapshotIncremental(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metrics-db.json")
+	fs := NewStorage(filename)
+	fs.SetRetention(Retention{Interval: time.Hour, KeepLast: 10})
+	ctx := context.Background()
+
+	counter := func(delta int64) []models.MetricsWithValue {
+		return []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: delta}}
+	}
+	countSnapshots := func() int {
+		snapshots, err := fs.ListSnapshots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(snapshots)
+	}
+
+	if err := fs.UpdateList(ctx, counter(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.UpdateChanged(ctx, counter(2)); err != nil {
+		t.Fatal(err)
+	}
+	if got := countSnapshots(); got != 1 {
+		t.Fatalf("ListSnapshots() within interval returned %d snapshots, want 1", got)
+	}
+
+	// The interval has passed, the next incremental flush has to end up in
+	// a snapshot.
+	fs.lastSnapshot = fs.lastSnapshot.Add(-2 * time.Hour)
+	if err := fs.UpdateChanged(ctx, counter(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := fs.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("ListSnapshots() after interval returned %d snapshots, want 2", len(snapshots))
+	}
+	path, err := fs.SnapshotPath(snapshots[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewStorage(path).GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := counter(3); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() of newest snapshot = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"
+	SyncInterval SyncPolicy = "interval"
+	SyncNever    SyncPolicy = "never"
+)
+
+var ErrUnknownSyncPolicy = errors.New("unknown wal sync policy")
+
+// WAL is an append-only log of updates split into numbered segments
+// <filename>.<seq>. Every Append writes one line with the whole batch, so
+// a batch is either replayed completely or not at all.
+//
+// With SyncInterval the log is synced in background, so an update reaches
+// disk within interval even if no other update follows it.
+type WAL struct {
+	filename string
+	policy   SyncPolicy
+	interval time.Duration
+	unsynced bool
+	syncErr  error
+	seq      int
+	file     *os.File
+	size     int64
+	mu       sync.Mutex
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func OpenWAL(filename string, policy SyncPolicy, interval time.Duration) (*WAL, error) {
+	if policy != SyncAlways && policy != SyncInterval && policy != SyncNever {
+		return nil, ErrUnknownSyncPolicy
+	}
+	if policy == SyncInterval && interval <= 0 {
+		policy = SyncAlways
+	}
+
+	w := &WAL{
+		filename: filename,
+		policy:   policy,
+		interval: interval,
+	}
+
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		w.seq = segments[len(segments)-1]
+	}
+
+	// Never append to an existing segment: its tail may be torn.
+	if err := w.openSegment(w.seq + 1); err != nil {
+		return nil, err
+	}
+
+	if policy == SyncInterval {
+		w.done = make(chan struct{})
+		w.stopped = make(chan struct{})
+		go w.syncEvery(interval)
+	}
+	return w, nil
+}
+
+func (w *WAL) syncEvery(interval time.Duration) {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		if w.unsynced {
+			// Reported by the next Append, as an update synced by itself
+			// would be.
+			w.syncErr = w.sync()
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *WAL) Append(list []models.MetricsWithValue) error {
+	metrics := make([]models.Metrics, 0, len(list))
+	for _, metric := range list {
+		metrics = append(metrics, models.ToMetric(metric))
+	}
+
+	line, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.syncErr; err != nil {
+		w.syncErr = nil
+		return err
+	}
+
+	if _, err := w.file.Write(line); err != nil {
+		// A line written partly would break reading of lines after it.
+		if truncErr := w.file.Truncate(w.size); truncErr != nil {
+			return errors.Join(err, truncErr)
+		}
+		return err
+	}
+	w.size += int64(len(line))
+	w.unsynced = true
+
+	if w.policy == SyncAlways {
+		return w.sync()
+	}
+	return nil
+}
+
+// Rotate closes the current segment and starts a new one. It returns the
+// sequence number of the closed segment, everything up to it can be
+// removed with Commit once a snapshot containing it has been written.
+func (w *WAL) Rotate() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seq := w.seq
+	if err := w.sync(); err != nil {
+		return 0, err
+	}
+	if err := w.file.Close(); err != nil {
+		return 0, err
+	}
+	if err := w.openSegment(seq + 1); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+func (w *WAL) Commit(seq int) error {
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		if s > seq {
+			break
+		}
+		if err := os.Remove(w.segmentName(s)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay returns updates logged after segment checkpoint, segments up to
+// it are contained in the backup already. Segments may be left over from a
+// crash between writing the backup and Commit. New segments are numbered
+// after checkpoint even if every segment has been removed.
+func (w *WAL) Replay(checkpoint int) ([]models.MetricsWithValue, error) {
+	if err := w.skip(checkpoint); err != nil {
+		return nil, err
+	}
+
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.MetricsWithValue, 0)
+	for _, seq := range segments {
+		if seq <= checkpoint {
+			continue
+		}
+		segment, err := readSegment(w.segmentName(seq))
+		if err != nil {
+			return nil, fmt.Errorf("wal segment %d: %w", seq, err)
+		}
+		list = append(list, segment...)
+	}
+	return list, nil
+}
+
+// skip moves the current segment past checkpoint, updates appended to it
+// would be skipped by the next replay otherwise.
+func (w *WAL) skip(checkpoint int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.seq > checkpoint {
+		return nil
+	}
+	if err := w.sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.openSegment(checkpoint + 1)
+}
+
+func (w *WAL) Close() error {
+	if w.done != nil {
+		close(w.done)
+		<-w.stopped
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *WAL) sync() error {
+	if w.policy == SyncNever || !w.unsynced {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.unsynced = false
+	return nil
+}
+
+func (w *WAL) openSegment(seq int) error {
+	file, err := os.OpenFile(w.segmentName(seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.seq = seq
+	w.size = info.Size()
+	return nil
+}
+
+func (w *WAL) segmentName(seq int) string {
+	return fmt.Sprintf("%s.%06d", w.filename, seq)
+}
+
+func (w *WAL) segments() ([]int, error) {
+	matches, err := filepath.Glob(w.filename + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]int, 0, len(matches))
+	for _, match := range matches {
+		seq, err := strconv.Atoi(strings.TrimPrefix(match, w.filename+"."))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func readSegment(filename string) ([]models.MetricsWithValue, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := make([]models.MetricsWithValue, 0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without trailing newline is a write torn by a crash,
+			// the update was never acknowledged, so it is dropped.
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var metrics []models.Metrics
+		if err := json.Unmarshal(line, &metrics); err != nil {
+			return nil, err
+		}
+		for _, metric := range metrics {
+			list = append(list, models.ToMetricWithValue(metric))
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+func TestWAL_Replay(t *testing.T) {
+	counter := models.MetricsWithValue{ID: "testCounter", MType: "counter", Delta: 1}
+	gauge := models.MetricsWithValue{ID: "testGauge", MType: "gauge", Value: 1.5}
+
+	tests := []struct {
+		name    string
+		batches [][]models.MetricsWithValue
+		tail    string
+		want    []models.MetricsWithValue
+		wantErr bool
+	}{
+		{
+			name:    "batches in order",
+			batches: [][]models.MetricsWithValue{{counter}, {gauge, counter}},
+			want:    []models.MetricsWithValue{counter, gauge, counter},
+		},
+		{
+			name:    "torn last record",
+			batches: [][]models.MetricsWithValue{{counter}},
+			tail:    `[{"id":"testGauge","type":"gau`,
+			want:    []models.MetricsWithValue{counter},
+		},
+		{
+			name:    "corrupt complete record",
+			batches: [][]models.MetricsWithValue{{counter}},
+			tail:    "garbage\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "wal")
+
+			w, err := OpenWAL(filename, SyncAlways, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, batch := range tt.batches {
+				if err := w.Append(batch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := w.file.WriteString(tt.tail); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+
+			got, err := w.Replay(0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Replay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Replay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWAL_RotateCommit(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "wal")
+	first := models.MetricsWithValue{ID: "first", MType: "counter", Delta: 1}
+	second := models.MetricsWithValue{ID: "second", MType: "counter", Delta: 2}
+
+	w, err := OpenWAL(filename, SyncInterval, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Append([]models.MetricsWithValue{first})
+	seq, err := w.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append([]models.MetricsWithValue{second})
+
+	got, _ := w.Replay(0)
+	if want := []models.MetricsWithValue{first, second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay() before Commit() = %v, want %v", got, want)
+	}
+
+	if err := w.Commit(seq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(w.segmentName(seq)); !os.IsNotExist(err) {
+		t.Errorf("Commit() kept segment %d", seq)
+	}
+
+	got, _ = w.Replay(0)
+	if want := []models.MetricsWithValue{second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay() after Commit() = %v, want %v", got, want)
+	}
+	w.Close()
+
+	reopened, err := OpenWAL(filename, SyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if reopened.seq <= seq+1 {
+		t.Errorf("OpenWAL() appends to segment %d, want new segment after %d", reopened.seq, seq+1)
+	}
+	got, _ = reopened.Replay(0)
+	if want := []models.MetricsWithValue{second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay() after reopen = %v, want %v", got, want)
+	}
+}
+
+func TestWAL_ReplayCheckpoint(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "wal")
+	first := models.MetricsWithValue{ID: "first", MType: "counter", Delta: 1}
+	second := models.MetricsWithValue{ID: "second", MType: "counter", Delta: 2}
+
+	w, err := OpenWAL(filename, SyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append([]models.MetricsWithValue{first})
+	seq, err := w.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append([]models.MetricsWithValue{second})
+	w.Close()
+
+	// crash between writing the backup and Commit: segment seq is in the
+	// backup already, but still on disk
+	reopened, err := OpenWAL(filename, SyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Replay(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []models.MetricsWithValue{second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay(%d) = %v, want %v", seq, got, want)
+	}
+	reopened.Close()
+
+	// every segment committed, the backup knows a later one than the log
+	if err := reopened.Commit(reopened.seq); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := reopened.seq + 10
+
+	restarted, err := OpenWAL(filename, SyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+
+	if _, err := restarted.Replay(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	restarted.Append([]models.MetricsWithValue{first})
+
+	got, _ = restarted.Replay(checkpoint)
+	if want := []models.MetricsWithValue{first}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replay(%d) after Append() = %v, want %v", checkpoint, got, want)
+	}
+}
+
+func TestWAL_syncInterval(t *testing.T) {
+	w, err := OpenWAL(filepath.Join(t.TempDir(), "wal"), SyncInterval, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Append([]models.MetricsWithValue{{ID: "idle", MType: "counter", Delta: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No further Append comes, the update is synced in background anyway.
+	deadline := time.Now().Add(time.Second)
+	for {
+		w.mu.Lock()
+		unsynced := w.unsynced
+		w.mu.Unlock()
+
+		if !unsynced {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle log not synced within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOpenWAL_unknownPolicy(t *testing.T) {
+	if _, err := OpenWAL(filepath.Join(t.TempDir(), "wal"), SyncPolicy("sometimes"), 0); err != ErrUnknownSyncPolicy {
+		t.Errorf("OpenWAL() error = %v, want %v", err, ErrUnknownSyncPolicy)
+	}
+}
@@ -46,6 +46,10 @@ func NewMemGauge() *MemGauge {
 }
 
 func (ms *MemStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	if err := ctx.Err(); err != nil {
+		return metric, err
+	}
+
 	var status bool
 
 	switch metric.MType {
@@ -74,6 +78,10 @@ func (ms *MemStorage) Get(ctx context.Context, metric models.MetricsWithValue) (
 }
 
 func (ms *MemStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	ms.Counter.Lock()
 	ms.Gauge.Lock()
 
@@ -103,6 +111,10 @@ func (ms *MemStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, e
 }
 
 func (ms *MemStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	switch metric.MType {
 	case "counter":
 		ms.Counter.Lock()
@@ -116,15 +128,55 @@ func (ms *MemStorage) Update(ctx context.Context, metric models.MetricsWithValue
 	return nil
 }
 
+// UpdateList checks the context once and then applies the whole list, so a
+// batch failed with an error is never partly applied and counted twice
+// after a retry.
 func (ms *MemStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.Counter.Lock()
+	ms.Gauge.Lock()
+	defer ms.Counter.Unlock()
+	defer ms.Gauge.Unlock()
+
+	for _, metric := range list {
+		switch metric.MType {
+		case "counter":
+			ms.Counter.mem[metric.ID] += metric.Delta
+		case "gauge":
+			ms.Gauge.mem[metric.ID] = metric.Value
+		}
+	}
+	return nil
+}
+
+func (ms *MemStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ms.Counter.Lock()
+	ms.Gauge.Lock()
+	defer ms.Counter.Unlock()
+	defer ms.Gauge.Unlock()
+
 	for _, metric := range list {
-		ms.Update(ctx, metric)
+		switch metric.MType {
+		case "counter":
+			ms.Counter.mem[metric.ID] = metric.Delta
+		case "gauge":
+			ms.Gauge.mem[metric.ID] = metric.Value
+		}
 	}
 	return nil
 }
 
 func (ms *MemStorage) Ping() error { return nil }
 
+func (ms *MemStorage) Close() error { return nil }
+
 func (ms *MemStorage) SetRetryCount(attempts int) {}
 
 func (ms *MemStorage) SetRetryStartWaitTime(sleep time.Duration) {}
@@ -3,10 +3,12 @@ package inmemory
 import (
 	"context"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/storagetest"
 )
 
 func TestMemStorage_Get(t *testing.T) {
@@ -260,3 +262,70 @@ func TestMemStorage_UpdateList(t *testing.T) {
 		})
 	}
 }
+
+// cancelAfterCheck is a context cancelled right after its first check, as
+// if the request was cancelled while a batch was being applied.
+type cancelAfterCheck struct {
+	context.Context
+	checks atomic.Int64
+}
+
+func (c *cancelAfterCheck) Err() error {
+	if c.checks.Add(1) > 1 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// cancelledBatchTests check that a batch is applied either whole or not at
+// all, so that retrying a failed batch does not count it twice.
+var cancelledBatchTests = []struct {
+	name      string
+	ctx       func() context.Context
+	wantErr   bool
+	wantDelta int64
+}{
+	{
+		name: "cancelled before batch",
+		ctx: func() context.Context {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx
+		},
+		wantErr:   true,
+		wantDelta: 0,
+	},
+	{
+		name: "cancelled while applying batch",
+		ctx: func() context.Context {
+			return &cancelAfterCheck{Context: context.Background()}
+		},
+		wantErr:   false,
+		wantDelta: 3,
+	},
+}
+
+func TestMemStorage_UpdateListCancelled(t *testing.T) {
+	list := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 1},
+		{ID: "c", MType: "counter", Delta: 2},
+	}
+
+	for _, tt := range cancelledBatchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := NewStorage()
+			if err := ms.UpdateList(tt.ctx(), list); (err != nil) != tt.wantErr {
+				t.Errorf("UpdateList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := ms.Counter.mem["c"]; got != tt.wantDelta {
+				t.Errorf("counter = %d, want %d", got, tt.wantDelta)
+			}
+		})
+	}
+}
+
+func TestMemStorage_conformance(t *testing.T) {
+	storagetest.Main(t, func(t *testing.T) storagetest.MainStorage {
+		return NewStorage()
+	})
+}
@@ -0,0 +1,319 @@
+package inmemory
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+// ShardedStorage spreads series over shards by hash of their names, each
+// shard with a lock of its own. Values are atomics, so updates of existing
+// series hold the shard lock for reading only and don't wait for each
+// other, the lock is taken for writing only to add a series or to copy the
+// shard for iterators.
+type ShardedStorage struct {
+	shards []*shard
+	mask   uint32
+
+	// epoch grows with every Iterate. A shard not captured in the current
+	// epoch is copied for open iterators before it is changed, so that they
+	// see it as it was when they were made.
+	epoch     atomic.Uint64
+	iterMu    sync.Mutex
+	iterators []*Iterator
+}
+
+type shard struct {
+	sync.RWMutex
+	index    int
+	counters map[string]*atomic.Int64
+	gauges   map[string]*atomic.Uint64
+	// captured is the epoch shard was last copied in, guarded by the lock.
+	captured uint64
+}
+
+// NewShardedStorage rounds shards up to a power of two.
+func NewShardedStorage(shards int) *ShardedStorage {
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	s := &ShardedStorage{
+		shards: make([]*shard, n),
+		mask:   uint32(n - 1),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			index:    i,
+			counters: make(map[string]*atomic.Int64),
+			gauges:   make(map[string]*atomic.Uint64),
+		}
+	}
+	return s
+}
+
+// shard picks shard by FNV-1a hash of id.
+func (s *ShardedStorage) shard(id string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return s.shards[h&s.mask]
+}
+
+func (s *ShardedStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	if err := ctx.Err(); err != nil {
+		return metric, err
+	}
+
+	sh := s.shard(metric.ID)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	switch metric.MType {
+	case "counter":
+		if value, ok := sh.counters[metric.ID]; ok {
+			metric.Delta = value.Load()
+			return metric, nil
+		}
+	case "gauge":
+		if value, ok := sh.gauges[metric.ID]; ok {
+			metric.Value = math.Float64frombits(value.Load())
+			return metric, nil
+		}
+	}
+	return metric, errors.New("unknown metric")
+}
+
+// GetList collects metrics from Iterate.
+func (s *ShardedStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]models.MetricsWithValue, 0)
+	for it := s.Iterate(); it.Next(); {
+		list = append(list, it.Metric())
+	}
+	return list, nil
+}
+
+func (s *ShardedStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.update(metric)
+	return nil
+}
+
+// UpdateList checks the context once and then applies the whole list, so a
+// batch failed with an error is never partly applied.
+func (s *ShardedStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, metric := range list {
+		s.update(metric)
+	}
+	return nil
+}
+
+func (s *ShardedStorage) update(metric models.MetricsWithValue) {
+	sh := s.shard(metric.ID)
+	switch metric.MType {
+	case "counter":
+		change(s, sh, sh.counters, metric.ID, func(value *atomic.Int64) { value.Add(metric.Delta) })
+	case "gauge":
+		change(s, sh, sh.gauges, metric.ID, func(value *atomic.Uint64) { value.Store(math.Float64bits(metric.Value)) })
+	}
+}
+
+func (s *ShardedStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, metric := range list {
+		sh := s.shard(metric.ID)
+		switch metric.MType {
+		case "counter":
+			change(s, sh, sh.counters, metric.ID, func(value *atomic.Int64) { value.Store(metric.Delta) })
+		case "gauge":
+			change(s, sh, sh.gauges, metric.ID, func(value *atomic.Uint64) { value.Store(math.Float64bits(metric.Value)) })
+		}
+	}
+	return nil
+}
+
+func (s *ShardedStorage) Ping() error { return nil }
+
+func (s *ShardedStorage) Close() error { return nil }
+
+func (s *ShardedStorage) SetRetryCount(attempts int) {}
+
+func (s *ShardedStorage) SetRetryStartWaitTime(sleep time.Duration) {}
+
+func (s *ShardedStorage) SetRetryIncreaseWaitTime(delta time.Duration) {}
+
+// change runs f on value of series id in values of sh, adding the series
+// if there is none. f runs with the shard lock held for reading, and only
+// once the shard is captured in the current epoch, so that iterators made
+// before don't see the change.
+func change[T any](s *ShardedStorage, sh *shard, values map[string]*T, id string, f func(value *T)) {
+	for {
+		sh.RLock()
+		if sh.captured == s.epoch.Load() {
+			if value, ok := values[id]; ok {
+				f(value)
+				sh.RUnlock()
+				return
+			}
+		}
+		sh.RUnlock()
+
+		sh.Lock()
+		s.capture(sh)
+		if _, ok := values[id]; !ok {
+			values[id] = new(T)
+		}
+		sh.Unlock()
+	}
+}
+
+// capture copies sh for open iterators that don't have it yet, the caller
+// holds the shard lock for writing. Iterate can't run meanwhile, so the
+// copy is taken after every iterator it goes to was made.
+func (s *ShardedStorage) capture(sh *shard) {
+	s.iterMu.Lock()
+	defer s.iterMu.Unlock()
+
+	var list []models.MetricsWithValue
+	for _, it := range s.iterators {
+		if it.captured[sh.index] {
+			continue
+		}
+		if list == nil {
+			list = sh.appendTo(make([]models.MetricsWithValue, 0, len(sh.counters)+len(sh.gauges)))
+		}
+		it.shards[sh.index] = list
+		it.captured[sh.index] = true
+	}
+	sh.captured = s.epoch.Load()
+}
+
+// appendTo copies shard, the caller holds its lock.
+func (sh *shard) appendTo(list []models.MetricsWithValue) []models.MetricsWithValue {
+	for id, value := range sh.counters {
+		list = append(list, models.MetricsWithValue{ID: id, MType: "counter", Delta: value.Load()})
+	}
+	for id, value := range sh.gauges {
+		list = append(list, models.MetricsWithValue{ID: id, MType: "gauge", Value: math.Float64frombits(value.Load())})
+	}
+	return list
+}
+
+// Iterator walks over a point-in-time snapshot of storage one shard at a
+// time, with no lock held between calls of Next. A shard is copied when a
+// write is about to change it or when the iterator reaches it, whichever
+// comes first, so writers never wait for the whole storage to be copied.
+// Every series is returned exactly once with the value it had at Iterate,
+// series added later are not returned.
+type Iterator struct {
+	storage *ShardedStorage
+	// shards and captured are filled by capture, guarded by iterMu.
+	shards   [][]models.MetricsWithValue
+	captured []bool
+	next     int
+	buf      []models.MetricsWithValue
+	metric   models.MetricsWithValue
+	closed   bool
+}
+
+// Iterate makes an iterator over storage as it is now. The iterator has to
+// be walked to the end or closed, until then writers copy shards for it.
+func (s *ShardedStorage) Iterate() *Iterator {
+	it := &Iterator{
+		storage:  s,
+		shards:   make([][]models.MetricsWithValue, len(s.shards)),
+		captured: make([]bool, len(s.shards)),
+	}
+
+	s.iterMu.Lock()
+	defer s.iterMu.Unlock()
+	s.iterators = append(s.iterators, it)
+	s.epoch.Add(1)
+	return it
+}
+
+// Next moves to the next metric and reports whether there is one, the
+// iterator is closed once there is none.
+func (it *Iterator) Next() bool {
+	for len(it.buf) == 0 {
+		if it.closed || it.next >= len(it.storage.shards) {
+			it.Close()
+			return false
+		}
+		it.buf = it.take(it.next)
+		it.next++
+	}
+	it.metric = it.buf[0]
+	it.buf = it.buf[1:]
+	return true
+}
+
+// Metric returns the metric Next moved to.
+func (it *Iterator) Metric() models.MetricsWithValue {
+	return it.metric
+}
+
+// Close stops iteration early, so that writers no longer copy shards for
+// the iterator.
+func (it *Iterator) Close() {
+	s := it.storage
+	s.iterMu.Lock()
+	defer s.iterMu.Unlock()
+
+	it.closed = true
+	it.buf = nil
+	for i, other := range s.iterators {
+		if other == it {
+			last := len(s.iterators) - 1
+			s.iterators[i] = s.iterators[last]
+			s.iterators[last] = nil
+			s.iterators = s.iterators[:last]
+			break
+		}
+	}
+}
+
+// take returns copy of shard i made for the iterator, copying the shard
+// now if no write has changed it since Iterate.
+func (it *Iterator) take(i int) []models.MetricsWithValue {
+	s := it.storage
+
+	s.iterMu.Lock()
+	captured := it.captured[i]
+	s.iterMu.Unlock()
+
+	if !captured {
+		sh := s.shards[i]
+		sh.Lock()
+		s.capture(sh)
+		sh.Unlock()
+	}
+
+	s.iterMu.Lock()
+	defer s.iterMu.Unlock()
+	list := it.shards[i]
+	it.shards[i] = nil
+	return list
+}
@@ -0,0 +1,290 @@
+package inmemory
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/storagetest"
+)
+
+func TestShardedStorage_conformance(t *testing.T) {
+	for _, shards := range []int{1, 64} {
+		t.Run(fmt.Sprintf("%d shards", shards), func(t *testing.T) {
+			storagetest.Main(t, func(t *testing.T) storagetest.MainStorage {
+				return NewShardedStorage(shards)
+			})
+		})
+	}
+}
+
+func TestShardedStorage_UpdateListCancelled(t *testing.T) {
+	list := []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 1},
+		{ID: "c", MType: "counter", Delta: 2},
+	}
+
+	for _, tt := range cancelledBatchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewShardedStorage(4)
+			if err := s.UpdateList(tt.ctx(), list); (err != nil) != tt.wantErr {
+				t.Errorf("UpdateList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, _ := s.Get(context.Background(), models.MetricsWithValue{ID: "c", MType: "counter"})
+			if got.Delta != tt.wantDelta {
+				t.Errorf("counter = %d, want %d", got.Delta, tt.wantDelta)
+			}
+		})
+	}
+}
+
+func TestNewShardedStorage(t *testing.T) {
+	tests := []struct {
+		shards int
+		want   int
+	}{
+		{shards: 0, want: 1},
+		{shards: 1, want: 1},
+		{shards: 3, want: 4},
+		{shards: 64, want: 64},
+		{shards: 100, want: 128},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d", tt.shards), func(t *testing.T) {
+			s := NewShardedStorage(tt.shards)
+			if len(s.shards) != tt.want || int(s.mask) != tt.want-1 {
+				t.Errorf("NewShardedStorage(%d) has %d shards, mask %d, want %d", tt.shards, len(s.shards), s.mask, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardedStorage_Iterate(t *testing.T) {
+	s := NewShardedStorage(8)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		s.Update(ctx, models.MetricsWithValue{ID: fmt.Sprintf("c%d", i), MType: "counter", Delta: 1})
+	}
+
+	it := s.Iterate()
+	if !it.Next() {
+		t.Fatal("Next() = false on filled storage")
+	}
+	seen := map[string]int64{it.Metric().ID: it.Metric().Delta}
+
+	// The iterator holds no lock between calls, so changing and adding
+	// series in any shard must not block, and it must not see the changes.
+	for i := 0; i < 100; i++ {
+		s.Update(ctx, models.MetricsWithValue{ID: fmt.Sprintf("c%d", i), MType: "counter", Delta: 1})
+		s.Update(ctx, models.MetricsWithValue{ID: fmt.Sprintf("g%d", i), MType: "gauge", Value: 1})
+	}
+
+	for it.Next() {
+		metric := it.Metric()
+		if _, ok := seen[metric.ID]; ok {
+			t.Errorf("Next() returned %s twice", metric.ID)
+		}
+		seen[metric.ID] = metric.Delta
+	}
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("c%d", i)
+		if delta, ok := seen[id]; !ok || delta != 1 {
+			t.Errorf("Next() returned %s = %d, %t, want value 1 it had at Iterate()", id, delta, ok)
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("Next() returned %d series, want 100 without ones added after Iterate()", len(seen))
+	}
+	if len(s.iterators) != 0 {
+		t.Errorf("%d iterators left open after walking to the end", len(s.iterators))
+	}
+}
+
+// TestShardedStorage_IterateConsistent checks snapshot across shards: a is
+// always updated before b, so any point in time has a equal to b or one
+// more.
+func TestShardedStorage_IterateConsistent(t *testing.T) {
+	s := NewShardedStorage(64)
+	ctx := context.Background()
+
+	a, b := "a", "b"
+	for s.shard(a) == s.shard(b) {
+		b += "b"
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.Update(ctx, models.MetricsWithValue{ID: a, MType: "counter", Delta: 1})
+				s.Update(ctx, models.MetricsWithValue{ID: b, MType: "counter", Delta: 1})
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	for i := 0; i < 100; i++ {
+		values := make(map[string]int64)
+		for it := s.Iterate(); it.Next(); {
+			values[it.Metric().ID] = it.Metric().Delta
+			runtime.Gosched()
+		}
+		if diff := values[a] - values[b]; diff != 0 && diff != 1 {
+			t.Fatalf("Iterate() saw %s = %d and %s = %d, not a point in time", a, values[a], b, values[b])
+		}
+	}
+}
+
+func TestIterator_Close(t *testing.T) {
+	s := NewShardedStorage(4)
+	ctx := context.Background()
+	s.Update(ctx, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1})
+
+	it := s.Iterate()
+	it.Close()
+	if it.Next() {
+		t.Error("Next() = true after Close()")
+	}
+	if len(s.iterators) != 0 {
+		t.Errorf("%d iterators left open after Close()", len(s.iterators))
+	}
+}
+
+type benchStorage interface {
+	Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error)
+	GetList(ctx context.Context) ([]models.MetricsWithValue, error)
+	Update(ctx context.Context, metric models.MetricsWithValue) error
+}
+
+func benchStorages() []struct {
+	name string
+	open func() benchStorage
+} {
+	return []struct {
+		name string
+		open func() benchStorage
+	}{
+		{name: "mutex", open: func() benchStorage { return NewStorage() }},
+		{name: "sharded", open: func() benchStorage { return NewShardedStorage(64) }},
+	}
+}
+
+func benchMetrics(n int) []models.MetricsWithValue {
+	list := make([]models.MetricsWithValue, 0, n)
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			list = append(list, models.MetricsWithValue{ID: fmt.Sprintf("counter%d", i), MType: "counter", Delta: 1})
+		} else {
+			list = append(list, models.MetricsWithValue{ID: fmt.Sprintf("gauge%d", i), MType: "gauge", Value: float64(i)})
+		}
+	}
+	return list
+}
+
+// BenchmarkStorage_Update updates random series from parallel goroutines,
+// as handlers do under agent load.
+func BenchmarkStorage_Update(b *testing.B) {
+	list := benchMetrics(1000)
+
+	for _, bs := range benchStorages() {
+		b.Run(bs.name, func(b *testing.B) {
+			s := bs.open()
+			ctx := context.Background()
+
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					s.Update(ctx, list[r.Intn(len(list))])
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStorage_mixed does one GetList per thousand calls, nine reads
+// per update otherwise.
+func BenchmarkStorage_mixed(b *testing.B) {
+	list := benchMetrics(1000)
+
+	for _, bs := range benchStorages() {
+		b.Run(bs.name, func(b *testing.B) {
+			s := bs.open()
+			ctx := context.Background()
+			for _, metric := range list {
+				s.Update(ctx, metric)
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for i := 0; pb.Next(); i++ {
+					metric := list[r.Intn(len(list))]
+					switch {
+					case i%1000 == 0:
+						s.GetList(ctx)
+					case i%10 == 0:
+						s.Update(ctx, metric)
+					default:
+						s.Get(ctx, metric)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStorage_UpdateWhileListing updates series while GetList runs
+// over and over, as backups and /metrics listing do. With a single lock
+// writers queue up behind every GetList.
+func BenchmarkStorage_UpdateWhileListing(b *testing.B) {
+	list := benchMetrics(10000)
+
+	for _, bs := range benchStorages() {
+		b.Run(bs.name, func(b *testing.B) {
+			s := bs.open()
+			ctx := context.Background()
+			for _, metric := range list {
+				s.Update(ctx, metric)
+			}
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						s.GetList(ctx)
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					s.Update(ctx, list[r.Intn(len(list))])
+				}
+			})
+			b.StopTimer()
+
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
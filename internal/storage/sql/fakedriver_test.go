@@ -0,0 +1,208 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDropped = errors.New("fake: connection reset by peer")
+
+// fakeStep is what the fake database does on one statement: it waits for
+// delay or until the context is done, then drops the connection, fails with
+// err or returns rows.
+type fakeStep struct {
+	delay time.Duration
+	drop  bool
+	err   error
+	rows  [][]driver.Value
+}
+
+type fakeCall struct {
+	query string
+	at    time.Time
+}
+
+// fakeConnector is a database/sql driver playing a script: every Exec,
+// Query or Ping takes the next step, statements past the script succeed
+// with no rows. Transactions always begin and commit, so a failure is
+// scripted on the statement inside.
+type fakeConnector struct {
+	mu     sync.Mutex
+	script []fakeStep
+	calls  []fakeCall
+	opened int
+}
+
+// newFakeStorage returns storage on a fake database following script.
+func newFakeStorage(t *testing.T, script ...fakeStep) (*SQLStorage, *fakeConnector) {
+	c := &fakeConnector{script: script}
+	db := sql.OpenDB(c)
+	t.Cleanup(func() { db.Close() })
+	return newStorage(db), c
+}
+
+func (c *fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.opened++
+	return &fakeConn{connector: c}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return fakeDriver{connector: c}
+}
+
+func (c *fakeConnector) next(query string) fakeStep {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, fakeCall{query: query, at: time.Now()})
+	if len(c.script) == 0 {
+		return fakeStep{}
+	}
+	step := c.script[0]
+	c.script = c.script[1:]
+	return step
+}
+
+func (c *fakeConnector) Calls() []fakeCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]fakeCall(nil), c.calls...)
+}
+
+func (c *fakeConnector) Opened() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.opened
+}
+
+type fakeDriver struct {
+	connector *fakeConnector
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return d.connector.Connect(context.Background())
+}
+
+type fakeConn struct {
+	connector *fakeConnector
+	dropped   bool
+}
+
+func (c *fakeConn) do(ctx context.Context, query string) (fakeStep, error) {
+	if c.dropped {
+		return fakeStep{}, driver.ErrBadConn
+	}
+
+	step := c.connector.next(query)
+	if step.delay > 0 {
+		timer := time.NewTimer(step.delay)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return step, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if step.drop {
+		c.dropped = true
+		return step, errDropped
+	}
+	return step, step.err
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.dropped {
+		return nil, driver.ErrBadConn
+	}
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.do(ctx, query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	step, err := c.do(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: step.rows}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, "ping")
+	return err
+}
+
+// IsValid and ResetSession keep database/sql from reusing a dropped
+// connection, the next attempt gets a new one as with a real server.
+func (c *fakeConn) IsValid() bool { return !c.dropped }
+
+func (c *fakeConn) ResetSession(ctx context.Context) error {
+	if c.dropped {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// CheckNamedValue accepts arguments of any type, such as slices passed to
+// unnest.
+func (c *fakeConn) CheckNamedValue(value *driver.NamedValue) error { return nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error { return nil }
+
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	columns := make([]string, len(r.rows[0]))
+	for i := range columns {
+		columns[i] = fmt.Sprintf("column%d", i)
+	}
+	return columns
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,316 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Resolution string
+
+const (
+	ResolutionRaw    Resolution = "raw"
+	ResolutionMinute Resolution = "1m"
+	ResolutionHour   Resolution = "1h"
+)
+
+const (
+	maintainInterval = time.Minute
+	partitionLayout  = "20060102"
+	// partitionsAhead is how many days after today get partitions, so that
+	// a few failed maintenance runs don't send samples to the default one.
+	partitionsAhead = 7
+	// partitionsLockID keeps instances sharing the database from creating
+	// the same partition at once.
+	partitionsLockID = 7243190114
+)
+
+var (
+	ErrUnknownResolution  = errors.New("unknown resolution")
+	ErrHistoryUnsupported = errors.New("sample history is not supported with pgx pool")
+	ErrHistoryPeriods     = errors.New("history periods must satisfy retention > 1h rollup age > 1m rollup age > 0")
+)
+
+// Sample aggregates values of a series within a bucket starting at Time,
+// raw samples have all values equal to the sample value and Count 1.
+type Sample struct {
+	Time  time.Time
+	Min   float64
+	Max   float64
+	Sum   float64
+	Count int64
+	Last  float64
+}
+
+// History describes how long samples are kept at each resolution: raw
+// samples older than MinuteAfter are rolled up into 1m aggregates, those
+// older than HourAfter into 1h ones, and everything older than Retention
+// is deleted.
+type History struct {
+	MinuteAfter time.Duration
+	HourAfter   time.Duration
+	Retention   time.Duration
+}
+
+func (h History) validate() error {
+	if h.MinuteAfter <= 0 || h.HourAfter <= h.MinuteAfter || h.Retention <= h.HourAfter {
+		return fmt.Errorf("%w: got %s, %s, %s", ErrHistoryPeriods, h.MinuteAfter, h.HourAfter, h.Retention)
+	}
+	return nil
+}
+
+// historyTable is an aggregate table and the source it is rolled up from.
+type historyTable struct {
+	name    string
+	source  string
+	unit    string
+	after   func(h History) time.Duration
+	fromRaw bool
+}
+
+var rollups = []historyTable{
+	{name: "samples_1m", source: "samples", unit: "minute", after: func(h History) time.Duration { return h.MinuteAfter }, fromRaw: true},
+	{name: "samples_1h", source: "samples_1m", unit: "hour", after: func(h History) time.Duration { return h.HourAfter }},
+}
+
+// withHistory makes upsert record new values of updated metrics as samples.
+func withHistory(upsert string, mtype string) string {
+	return `WITH upserted AS (` + upsert + ` RETURNING metric_id, metric_value)
+		INSERT INTO samples (metric_id, metric_type, ts, value)
+		SELECT metric_id, '` + mtype + `', now(), metric_value FROM upserted`
+}
+
+// Range returns samples of series with ts in [from, to) at resolution res.
+// Aggregates combine all tables holding the range, so the part of it not
+// rolled up yet is aggregated on the fly. Minutes already rolled up into
+// hours are not kept, at 1m resolution such part of the range comes as 1h
+// buckets.
+func (s *SQLStorage) Range(ctx context.Context, mtype string, id string, from time.Time, to time.Time, res Resolution) ([]Sample, error) {
+	var query string
+	switch res {
+	case ResolutionRaw:
+		query = `SELECT ts, value, value, value, 1, value FROM samples
+			WHERE metric_type = $1 AND metric_id = $2 AND ts >= $3 AND ts < $4
+			ORDER BY ts`
+	case ResolutionMinute:
+		query = rangeQuery("minute", "samples_1m", "samples_1h")
+	case ResolutionHour:
+		query = rangeQuery("hour", "samples_1m", "samples_1h")
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownResolution, res)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, mtype, id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := make([]Sample, 0)
+	for rows.Next() {
+		var sample Sample
+		if err := rows.Scan(&sample.Time, &sample.Min, &sample.Max, &sample.Sum, &sample.Count, &sample.Last); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+func rangeQuery(unit string, tables ...string) string {
+	const where = ` WHERE metric_type = $1 AND metric_id = $2 AND ts >= $3 AND ts < $4`
+
+	parts := []string{`SELECT ts, value AS min, value AS max, value AS sum, 1 AS count, value AS last FROM samples` + where}
+	for _, table := range tables {
+		parts = append(parts, `SELECT ts, min, max, sum, count, last FROM `+table+where)
+	}
+	return `SELECT date_trunc('` + unit + `', ts) AS bucket, min(min), max(max), sum(sum), sum(count)::bigint,
+		(array_agg(last ORDER BY ts DESC))[1]
+		FROM (` + strings.Join(parts, " UNION ALL ") + `) s
+		GROUP BY bucket ORDER BY bucket`
+}
+
+// MaintainHistory creates partitions for upcoming days, rolls up samples
+// that are old enough, deletes expired ones and drops emptied partitions. A
+// failed step doesn't stop the others, except that partitions are dropped
+// only after raw samples in them are rolled up.
+func (s *SQLStorage) MaintainHistory(ctx context.Context) error {
+	now := time.Now().UTC()
+	var errs []error
+
+	if err := s.createPartitions(ctx, now); err != nil {
+		errs = append(errs, fmt.Errorf("create partitions: %w", err))
+	}
+
+	rolledUp := true
+	for _, table := range rollups {
+		cutoff := now.Add(-table.after(s.history)).Truncate(unitDuration(table.unit))
+		if err := s.rollup(ctx, table, cutoff); err != nil {
+			errs = append(errs, fmt.Errorf("roll up %s: %w", table.name, err))
+			rolledUp = rolledUp && !table.fromRaw
+		}
+	}
+
+	expired := now.Add(-s.history.Retention)
+	for _, table := range []string{"samples", "samples_1m", "samples_1h"} {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE ts < $1", expired); err != nil {
+			errs = append(errs, fmt.Errorf("delete expired from %s: %w", table, err))
+		}
+	}
+
+	if rolledUp {
+		if err := s.dropPartitions(ctx, now.Add(-s.history.MinuteAfter)); err != nil {
+			errs = append(errs, fmt.Errorf("drop partitions: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// createPartitions makes daily partitions from today to partitionsAhead
+// days later, so that samples don't end up in the default partition.
+func (s *SQLStorage) createPartitions(ctx context.Context, now time.Time) error {
+	day := now.Truncate(24 * time.Hour)
+	for i := 0; i <= partitionsAhead; i++ {
+		if err := s.createPartition(ctx, day.AddDate(0, 0, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPartition attaches partition for the day starting at from. Samples
+// of that day already in the default partition would make postgres refuse
+// the partition, so they are moved into it before it is attached.
+func (s *SQLStorage) createPartition(ctx context.Context, from time.Time) error {
+	name := "samples_" + from.Format(partitionLayout)
+	to := from.AddDate(0, 0, 1)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", partitionsLockID); err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE "+name+" (LIKE samples)"); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `WITH moved AS (DELETE FROM samples_default WHERE ts >= $1 AND ts < $2 RETURNING *)
+		INSERT INTO `+name+` SELECT * FROM moved`, from, to)
+	if err != nil {
+		return err
+	}
+	attach := fmt.Sprintf(`ALTER TABLE samples ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+		name, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if _, err := tx.ExecContext(ctx, attach); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// dropPartitions drops daily partitions entirely before oldest, their
+// samples are already rolled up or expired.
+func (s *SQLStorage) dropPartitions(ctx context.Context, oldest time.Time) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'samples'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		day, err := time.Parse(partitionLayout, strings.TrimPrefix(name, "samples_"))
+		if err != nil {
+			continue
+		}
+		if day.AddDate(0, 0, 1).After(oldest) {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollup moves rows older than cutoff from source into aggregates of table,
+// cutoff is aligned to the bucket, so buckets are never split.
+func (s *SQLStorage) rollup(ctx context.Context, table historyTable, cutoff time.Time) error {
+	columns := "min(min), max(max), sum(sum), sum(count)::bigint, (array_agg(last ORDER BY ts DESC))[1]"
+	returning := "min, max, sum, count, last"
+	if table.fromRaw {
+		columns = "min(value), max(value), sum(value), count(*), (array_agg(value ORDER BY ts DESC))[1]"
+		returning = "value"
+	}
+
+	query := `WITH moved AS (DELETE FROM ` + table.source + ` WHERE ts < $1
+			RETURNING metric_id, metric_type, ts, ` + returning + `)
+		INSERT INTO ` + table.name + ` (metric_id, metric_type, ts, min, max, sum, count, last)
+		SELECT metric_id, metric_type, date_trunc('` + table.unit + `', ts), ` + columns + `
+		FROM moved GROUP BY metric_id, metric_type, date_trunc('` + table.unit + `', ts)
+		ON CONFLICT (metric_type, metric_id, ts) DO UPDATE SET
+			min = least(` + table.name + `.min, EXCLUDED.min),
+			max = greatest(` + table.name + `.max, EXCLUDED.max),
+			sum = ` + table.name + `.sum + EXCLUDED.sum,
+			count = ` + table.name + `.count + EXCLUDED.count,
+			last = EXCLUDED.last`
+
+	_, err := s.db.ExecContext(ctx, query, cutoff)
+	return err
+}
+
+func unitDuration(unit string) time.Duration {
+	if unit == "hour" {
+		return time.Hour
+	}
+	return time.Minute
+}
+
+func (s *SQLStorage) runHistory() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(maintainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), maintainInterval)
+			if err := s.MaintainHistory(ctx); err != nil {
+				s.logger().Errorw("error",
+					"maintain history", err,
+				)
+			}
+			cancel()
+		}
+	}
+}
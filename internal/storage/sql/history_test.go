@@ -0,0 +1,226 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+func TestSQLStorage_RangeUnknownResolution(t *testing.T) {
+	s := &SQLStorage{}
+	_, err := s.Range(context.Background(), "gauge", "testGauge", time.Now().Add(-time.Hour), time.Now(), "5m")
+	if !errors.Is(err, ErrUnknownResolution) {
+		t.Errorf("Range() error = %v, want %v", err, ErrUnknownResolution)
+	}
+}
+
+func TestHistory_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		history History
+		wantErr error
+	}{
+		{
+			name:    "defaults",
+			history: History{MinuteAfter: time.Hour, HourAfter: 7 * 24 * time.Hour, Retention: 30 * 24 * time.Hour},
+		},
+		{
+			name:    "zero minute rollup",
+			history: History{HourAfter: time.Hour, Retention: 2 * time.Hour},
+			wantErr: ErrHistoryPeriods,
+		},
+		{
+			name:    "hour rollup before minute rollup",
+			history: History{MinuteAfter: 2 * time.Hour, HourAfter: time.Hour, Retention: 3 * time.Hour},
+			wantErr: ErrHistoryPeriods,
+		},
+		{
+			name:    "retention equal to hour rollup",
+			history: History{MinuteAfter: time.Hour, HourAfter: 2 * time.Hour, Retention: 2 * time.Hour},
+			wantErr: ErrHistoryPeriods,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.history.validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewStorage_badHistory(t *testing.T) {
+	// Periods are checked before connecting, so no database is needed.
+	_, err := NewStorage(&config.ServerConfig{
+		Database:           "postgres://localhost:1/none",
+		DBHistory:          true,
+		DBRollupHourAfter:  time.Hour,
+		DBHistoryRetention: 2 * time.Hour,
+	})
+	if !errors.Is(err, ErrHistoryPeriods) {
+		t.Errorf("NewStorage() error = %v, want %v", err, ErrHistoryPeriods)
+	}
+}
+
+func TestSQLStorage_History(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := NewStorage(&config.ServerConfig{
+		Database:            dsn,
+		DBAutoMigrate:       true,
+		DBHistory:           true,
+		DBRollupMinuteAfter: time.Nanosecond,
+		DBRollupHourAfter:   24 * time.Hour,
+		DBHistoryRetention:  48 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.db.Exec("TRUNCATE counter, gauge, samples, samples_1m, samples_1h"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []float64{3, 1, 2} {
+		if err := s.Update(ctx, models.MetricsWithValue{ID: "testGauge", MType: "gauge", Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	raw, err := s.Range(ctx, "gauge", "testGauge", from, to, ResolutionRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 3 || raw[0].Last != 3 || raw[2].Last != 2 {
+		t.Fatalf("Range(raw) = %+v, want samples 3, 1, 2", raw)
+	}
+
+	// Tiny MinuteAfter rolls up everything before the current minute, the
+	// result has to be the same before and after rollup.
+	want, err := s.Range(ctx, "gauge", "testGauge", from, to, ResolutionHour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MaintainHistory(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Range(ctx, "gauge", "testGauge", from, to, ResolutionHour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || len(want) != 1 {
+		t.Fatalf("Range(1h) = %+v, want one bucket", got)
+	}
+	if got[0].Min != 1 || got[0].Max != 3 || got[0].Sum != 6 || got[0].Count != 3 || got[0].Last != 2 {
+		t.Errorf("Range(1h) = %+v, want min 1, max 3, sum 6, count 3, last 2", got[0])
+	}
+	if !got[0].Time.Equal(want[0].Time) {
+		t.Errorf("Range(1h) bucket = %v, want %v", got[0].Time, want[0].Time)
+	}
+}
+
+func TestSQLStorage_RangeMinuteFallsBackToHours(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := NewStorage(&config.ServerConfig{
+		Database:            dsn,
+		DBAutoMigrate:       true,
+		DBHistory:           true,
+		DBRollupMinuteAfter: time.Hour,
+		DBRollupHourAfter:   24 * time.Hour,
+		DBHistoryRetention:  30 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.db.Exec("TRUNCATE counter, gauge, samples, samples_1m, samples_1h"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Older than HourAfter, only the hour aggregate is left.
+	hour := time.Now().UTC().Add(-72 * time.Hour).Truncate(time.Hour)
+	if _, err := s.db.Exec(`INSERT INTO samples_1h (metric_id, metric_type, ts, min, max, sum, count, last)
+		VALUES ('testGauge', 'gauge', $1, 1, 3, 6, 3, 2)`, hour); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Range(ctx, "gauge", "testGauge", hour.Add(-time.Hour), hour.Add(2*time.Hour), ResolutionMinute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Range(1m) = %+v, want one bucket", got)
+	}
+	if !got[0].Time.Equal(hour) || got[0].Min != 1 || got[0].Max != 3 || got[0].Sum != 6 || got[0].Count != 3 || got[0].Last != 2 {
+		t.Errorf("Range(1m) = %+v, want bucket %v with min 1, max 3, sum 6, count 3, last 2", got[0], hour)
+	}
+}
+
+func TestSQLStorage_createPartitionsMovesDefault(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := NewStorage(&config.ServerConfig{
+		Database:            dsn,
+		DBAutoMigrate:       true,
+		DBHistory:           true,
+		DBRollupMinuteAfter: time.Hour,
+		DBRollupHourAfter:   24 * time.Hour,
+		DBHistoryRetention:  48 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A day far enough ahead to have no partition yet, its sample goes to
+	// the default partition.
+	ctx := context.Background()
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 100)
+	name := "samples_" + day.Format(partitionLayout)
+	defer s.db.Exec("DROP TABLE IF EXISTS " + name)
+
+	_, err = s.db.Exec("INSERT INTO samples (metric_id, metric_type, ts, value) VALUES ('testGauge', 'gauge', $1, 1)", day.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.createPartitions(ctx, day); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= partitionsAhead; i++ {
+		defer s.db.Exec("DROP TABLE IF EXISTS samples_" + day.AddDate(0, 0, i).Format(partitionLayout))
+	}
+
+	var moved, left int
+	if err := s.db.QueryRow("SELECT count(*) FROM " + name).Scan(&moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.QueryRow("SELECT count(*) FROM samples_default WHERE ts >= $1", day).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 || left != 0 {
+		t.Errorf("partition has %d samples and default %d, want sample moved out of default", moved, left)
+	}
+}
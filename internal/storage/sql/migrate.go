@@ -0,0 +1,64 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"github.com/h3ll0kitt1/observability/internal/schema"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationsLockID is the key of advisory lock taken while migrating, any
+// constant unique within the database works.
+const migrationsLockID = 7243190113
+
+type postgresDialect struct{}
+
+func (postgresDialect) Lock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID)
+	return err
+}
+
+func (postgresDialect) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationsLockID)
+	return err
+}
+
+func (postgresDialect) TableExists(ctx context.Context, conn *sql.Conn, name string) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists)
+	return exists, err
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) SingleTx() bool { return false }
+
+func OpenMigrator(dsn string) (*schema.Migrator, error) {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return schema.Open(db, postgresDialect{}, sub)
+}
+
+// migrate brings schema up to date, without autoMigrate it only checks
+// that nothing is pending.
+func migrate(ctx context.Context, db *sql.DB, autoMigrate bool) error {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	return schema.Migrate(ctx, db, postgresDialect{}, sub, autoMigrate)
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS counter(
+	metric_id varchar(512) primary key,
+	metric_value bigint not null);
+
+CREATE TABLE IF NOT EXISTS gauge(
+	metric_id varchar(512) primary key,
+	metric_value double precision not null);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS samples_1h;
+
+DROP TABLE IF EXISTS samples_1m;
+
+DROP TABLE IF EXISTS samples;
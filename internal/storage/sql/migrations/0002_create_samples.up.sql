@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS samples(
+	metric_id varchar(512) not null,
+	metric_type varchar(16) not null,
+	ts timestamptz not null,
+	value double precision not null) PARTITION BY RANGE (ts);
+
+CREATE INDEX IF NOT EXISTS samples_series_ts ON samples (metric_type, metric_id, ts);
+
+CREATE TABLE IF NOT EXISTS samples_default PARTITION OF samples DEFAULT;
+
+CREATE TABLE IF NOT EXISTS samples_1m(
+	metric_id varchar(512) not null,
+	metric_type varchar(16) not null,
+	ts timestamptz not null,
+	min double precision not null,
+	max double precision not null,
+	sum double precision not null,
+	count bigint not null,
+	last double precision not null,
+	primary key (metric_type, metric_id, ts));
+
+CREATE TABLE IF NOT EXISTS samples_1h(
+	metric_id varchar(512) not null,
+	metric_type varchar(16) not null,
+	ts timestamptz not null,
+	min double precision not null,
+	max double precision not null,
+	sum double precision not null,
+	count bigint not null,
+	last double precision not null,
+	primary key (metric_type, metric_id, ts));
@@ -0,0 +1,222 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/retry"
+)
+
+// PoolStorage talks to postgres through native pgx pool instead of
+// database/sql.
+type PoolStorage struct {
+	pool   *pgxpool.Pool
+	policy retry.Policy
+}
+
+type PoolStats struct {
+	AcquiredConns          int32
+	IdleConns              int32
+	TotalConns             int32
+	MaxConns               int32
+	AcquireCount           int64
+	EmptyAcquireCount      int64
+	CanceledAcquireCount   int64
+	AcquireDurationSeconds float64
+}
+
+func NewPoolStorage(cfg *config.ServerConfig) (*PoolStorage, error) {
+	if cfg.DBHistory {
+		return nil, ErrHistoryUnsupported
+	}
+
+	poolConfig, err := poolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Migrations runner works with database/sql, it gets a connection of
+	// its own for the time of migrating.
+	db := stdlib.OpenDB(*poolConfig.ConnConfig)
+	err = migrate(ctx, db, cfg.DBAutoMigrate)
+	db.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &PoolStorage{
+		pool:   pool,
+		policy: newPolicy(),
+	}, nil
+}
+
+// poolConfig applies pool flags on top of DSN, zero values keep pgx
+// defaults and parameters given in DSN such as pool_max_conns.
+func poolConfig(cfg *config.ServerConfig) (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DBMaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.DBMaxConns)
+	}
+	if cfg.DBMinConns > 0 {
+		poolConfig.MinConns = int32(cfg.DBMinConns)
+	}
+	if cfg.DBMaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.DBMaxConnLifetime
+	}
+	if cfg.DBMaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.DBMaxConnIdleTime
+	}
+	if cfg.DBHealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.DBHealthCheckPeriod
+	}
+
+	// Zero disables the statement cache, so the one left to DSN is negative.
+	if cfg.DBStatementCache >= 0 {
+		poolConfig.ConnConfig.StatementCacheCapacity = cfg.DBStatementCache
+	}
+	if cfg.DBStatementCache == 0 {
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	}
+	return poolConfig, nil
+}
+
+func (s *PoolStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	return retry.DoValue(ctx, s.policy, func(ctx context.Context) (models.MetricsWithValue, error) {
+		return s.get(ctx, metric)
+	})
+}
+
+func (s *PoolStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	return retry.DoValue(ctx, s.policy, s.getList)
+}
+
+func (s *PoolStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	return s.UpdateList(ctx, []models.MetricsWithValue{metric})
+}
+
+func (s *PoolStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	return retry.Do(ctx, s.policy, func(ctx context.Context) error {
+		return s.upsert(ctx, aggregate(list, true), addCounters)
+	})
+}
+
+func (s *PoolStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	return retry.Do(ctx, s.policy, func(ctx context.Context) error {
+		return s.upsert(ctx, aggregate(list, false), setCounters)
+	})
+}
+
+func (s *PoolStorage) Ping() error {
+	return retry.Do(context.Background(), s.policy, s.pool.Ping)
+}
+
+func (s *PoolStorage) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+func (s *PoolStorage) Stats() PoolStats {
+	stat := s.pool.Stat()
+	return PoolStats{
+		AcquiredConns:          stat.AcquiredConns(),
+		IdleConns:              stat.IdleConns(),
+		TotalConns:             stat.TotalConns(),
+		MaxConns:               stat.MaxConns(),
+		AcquireCount:           stat.AcquireCount(),
+		EmptyAcquireCount:      stat.EmptyAcquireCount(),
+		CanceledAcquireCount:   stat.CanceledAcquireCount(),
+		AcquireDurationSeconds: stat.AcquireDuration().Seconds(),
+	}
+}
+
+func (s *PoolStorage) SetRetryCount(attempts int) {
+	s.policy.Attempts = attempts
+}
+
+func (s *PoolStorage) SetRetryStartWaitTime(sleep time.Duration) {
+	s.policy.Wait = sleep
+}
+
+func (s *PoolStorage) SetRetryIncreaseWaitTime(delta time.Duration) {
+	s.policy.Increase = delta
+}
+
+func (s *PoolStorage) get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	var err error
+	switch metric.MType {
+	case "counter":
+		err = s.pool.QueryRow(ctx, "SELECT metric_value FROM counter WHERE metric_id = $1", metric.ID).Scan(&metric.Delta)
+	case "gauge":
+		err = s.pool.QueryRow(ctx, "SELECT metric_value FROM gauge WHERE metric_id = $1", metric.ID).Scan(&metric.Value)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return metric, ErrUnknownMetric
+	}
+	return metric, err
+}
+
+func (s *PoolStorage) getList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	list := make([]models.MetricsWithValue, 0)
+
+	rows, err := s.pool.Query(ctx, "SELECT metric_id, metric_value FROM counter")
+	if err != nil {
+		return nil, err
+	}
+	counters, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.MetricsWithValue, error) {
+		metric := models.MetricsWithValue{MType: "counter"}
+		err := row.Scan(&metric.ID, &metric.Delta)
+		return metric, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	list = append(list, counters...)
+
+	rows, err = s.pool.Query(ctx, "SELECT metric_id, metric_value FROM gauge")
+	if err != nil {
+		return nil, err
+	}
+	gauges, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.MetricsWithValue, error) {
+		metric := models.MetricsWithValue{MType: "gauge"}
+		err := row.Scan(&metric.ID, &metric.Value)
+		return metric, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(list, gauges...), nil
+}
+
+func (s *PoolStorage) upsert(ctx context.Context, b batch, upsertCounters string) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if len(b.counterIDs) > 0 {
+			if _, err := tx.Exec(ctx, upsertCounters, b.counterIDs, b.counterValues); err != nil {
+				return err
+			}
+		}
+		if len(b.gaugeIDs) > 0 {
+			if _, err := tx.Exec(ctx, upsertGauges, b.gaugeIDs, b.gaugeValues); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
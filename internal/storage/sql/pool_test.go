@@ -0,0 +1,170 @@
+package sql
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/storagetest"
+)
+
+func TestPoolConfig(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             config.ServerConfig
+		wantMaxConns    int32
+		wantMinConns    int32
+		wantLifetime    time.Duration
+		wantHealthCheck time.Duration
+		wantCache       int
+		wantExecMode    pgx.QueryExecMode
+	}{
+		{
+			name: "defaults from dsn",
+			cfg: config.ServerConfig{
+				Database:         "postgres://user@localhost/metrics?pool_max_conns=7&statement_cache_capacity=64",
+				DBStatementCache: -1,
+			},
+			wantMaxConns:    7,
+			wantLifetime:    time.Hour,
+			wantHealthCheck: time.Minute,
+			wantCache:       64,
+			wantExecMode:    pgx.QueryExecModeCacheStatement,
+		},
+		{
+			name: "pgx defaults",
+			cfg: config.ServerConfig{
+				Database:         "postgres://user@localhost/metrics",
+				DBMaxConns:       4,
+				DBStatementCache: -1,
+			},
+			wantMaxConns:    4,
+			wantLifetime:    time.Hour,
+			wantHealthCheck: time.Minute,
+			wantCache:       512,
+			wantExecMode:    pgx.QueryExecModeCacheStatement,
+		},
+		{
+			name: "flags override dsn",
+			cfg: config.ServerConfig{
+				Database:            "postgres://user@localhost/metrics?pool_max_conns=7",
+				DBMaxConns:          20,
+				DBMinConns:          2,
+				DBMaxConnLifetime:   10 * time.Minute,
+				DBHealthCheckPeriod: 5 * time.Second,
+				DBStatementCache:    100,
+			},
+			wantMaxConns:    20,
+			wantMinConns:    2,
+			wantLifetime:    10 * time.Minute,
+			wantHealthCheck: 5 * time.Second,
+			wantCache:       100,
+			wantExecMode:    pgx.QueryExecModeCacheStatement,
+		},
+		{
+			name: "statement cache disabled",
+			cfg: config.ServerConfig{
+				Database:   "postgres://user@localhost/metrics",
+				DBMaxConns: 4,
+			},
+			wantMaxConns:    4,
+			wantLifetime:    time.Hour,
+			wantHealthCheck: time.Minute,
+			wantExecMode:    pgx.QueryExecModeExec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := poolConfig(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.MaxConns != tt.wantMaxConns || got.MinConns != tt.wantMinConns {
+				t.Errorf("conns = %d/%d, want %d/%d", got.MinConns, got.MaxConns, tt.wantMinConns, tt.wantMaxConns)
+			}
+			if got.MaxConnLifetime != tt.wantLifetime {
+				t.Errorf("MaxConnLifetime = %v, want %v", got.MaxConnLifetime, tt.wantLifetime)
+			}
+			if got.HealthCheckPeriod != tt.wantHealthCheck {
+				t.Errorf("HealthCheckPeriod = %v, want %v", got.HealthCheckPeriod, tt.wantHealthCheck)
+			}
+			if got.ConnConfig.StatementCacheCapacity != tt.wantCache || got.ConnConfig.DefaultQueryExecMode != tt.wantExecMode {
+				t.Errorf("statement cache = %d %v, want %d %v", got.ConnConfig.StatementCacheCapacity,
+					got.ConnConfig.DefaultQueryExecMode, tt.wantCache, tt.wantExecMode)
+			}
+		})
+	}
+}
+
+func TestPoolStorage_conformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	storagetest.Main(t, func(t *testing.T) storagetest.MainStorage {
+		s, err := NewPoolStorage(&config.ServerConfig{Database: dsn, DBAutoMigrate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+
+		if _, err := s.pool.Exec(context.Background(), "TRUNCATE counter, gauge"); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestPoolStorage_UpdateList(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := NewPoolStorage(&config.ServerConfig{Database: dsn, DBAutoMigrate: true, DBStatementCache: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.pool.Exec(ctx, "TRUNCATE counter, gauge"); err != nil {
+		t.Fatal(err)
+	}
+
+	list := []models.MetricsWithValue{
+		{ID: "testCounter", MType: "counter", Delta: 1},
+		{ID: "testCounter", MType: "counter", Delta: 2},
+		{ID: "testGauge", MType: "gauge", Value: 1.5},
+	}
+	if err := s.UpdateList(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.MetricsWithValue{
+		{ID: "testCounter", MType: "counter", Delta: 3},
+		{ID: "testGauge", MType: "gauge", Value: 1.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() = %v, want %v", got, want)
+	}
+
+	if _, err := s.Get(ctx, models.MetricsWithValue{ID: "unknown", MType: "gauge"}); err == nil {
+		t.Error("Get() of unknown metric succeeded")
+	}
+	if stats := s.Stats(); stats.AcquireCount == 0 {
+		t.Errorf("Stats() = %+v, want acquired connections counted", stats)
+	}
+}
@@ -4,184 +4,175 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
 
 	"github.com/h3ll0kitt1/observability/internal/config"
 	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/retry"
 )
 
-type SQLStorage struct {
-	db      *sql.DB
-	retrier retrier
-}
+var ErrUnknownMetric = errors.New("unknown metric name")
 
-type retrier struct {
-	attempts   int
-	time       time.Duration
-	delta      time.Duration
-	errToRetry map[string]bool
+type SQLStorage struct {
+	db     *sql.DB
+	policy retry.Policy
+
+	// recordHistory makes every update recorded as a sample, kept as
+	// history describes.
+	recordHistory bool
+	history       History
+	stop          chan struct{}
+	done          chan struct{}
+
+	mu  sync.Mutex
+	log *zap.SugaredLogger
 }
 
 func NewStorage(cfg *config.ServerConfig) (*SQLStorage, error) {
+	history := History{
+		MinuteAfter: cfg.DBRollupMinuteAfter,
+		HourAfter:   cfg.DBRollupHourAfter,
+		Retention:   cfg.DBHistoryRetention,
+	}
+	if cfg.DBHistory {
+		if err := history.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	db, err := sql.Open("pgx", cfg.Database)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	query := `CREATE TABLE IF NOT EXISTS counter(
-		metric_id varchar(512) primary key, 
-		metric_value bigint not null)`
-	_, err = db.ExecContext(ctx, query)
-	if err != nil {
+	if err := migrate(ctx, db, cfg.DBAutoMigrate); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	query = `CREATE TABLE IF NOT EXISTS gauge(
-		metric_id varchar(512) primary key, 
-		metric_value double precision not null)`
-	_, err = db.ExecContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
+	s := newStorage(db)
+	if cfg.DBHistory {
+		s.recordHistory = true
+		s.history = history
+		if err := s.createPartitions(ctx, time.Now().UTC()); err != nil {
+			db.Close()
+			return nil, err
+		}
 
-	errToRetry := map[string]bool{
-		pgerrcode.ConnectionException:                     true,
-		pgerrcode.ConnectionDoesNotExist:                  true,
-		pgerrcode.ConnectionFailure:                       true,
-		pgerrcode.SQLClientUnableToEstablishSQLConnection: true,
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.runHistory()
 	}
+	return s, nil
+}
+
+// newStorage wraps db as it is, without migrating or checking its schema,
+// so that tests can pass a fake driver here.
+func newStorage(db *sql.DB) *SQLStorage {
 	return &SQLStorage{
-		db:      db,
-		retrier: retrier{attempts: 1, time: 0, delta: 0, errToRetry: errToRetry},
-	}, nil
+		db:     db,
+		policy: newPolicy(),
+		log:    zap.NewNop().Sugar(),
+	}
 }
 
-func (s *SQLStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
-	get := func() (models.MetricsWithValue, error) { return s.get(ctx, metric) }
-	metric, err := s.retryWithMetric(get)
-	if err != nil {
-		return metric, err
+func newPolicy() retry.Policy {
+	return retry.Policy{Attempts: 1, Jitter: 0.1, Retriable: retriable}
+}
+
+var errToRetry = map[string]bool{
+	pgerrcode.ConnectionException:                     true,
+	pgerrcode.ConnectionDoesNotExist:                  true,
+	pgerrcode.ConnectionFailure:                       true,
+	pgerrcode.SQLClientUnableToEstablishSQLConnection: true,
+}
+
+// retriable retries connection errors, errors reported by postgres are
+// retried only if they are about connection.
+func retriable(err error) bool {
+	if errors.Is(err, ErrUnknownMetric) {
+		return false
 	}
-	return metric, nil
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return errToRetry[pgErr.Code]
+	}
+	return true
+}
+
+func (s *SQLStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	return retry.DoValue(ctx, s.policy, func(ctx context.Context) (models.MetricsWithValue, error) {
+		return s.get(ctx, metric)
+	})
 }
 
 func (s *SQLStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
-	getList := func() ([]models.MetricsWithValue, error) { return s.getList(ctx) }
-	list, err := s.retryWithMetrics(getList)
-	if err != nil {
-		return nil, err
-	}
-	return list, nil
+	return retry.DoValue(ctx, s.policy, s.getList)
 }
 
 func (s *SQLStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
-	update := func() error { return s.update(ctx, metric) }
-	if err := s.retry(update); err != nil {
-		return err
-	}
-	return nil
+	return retry.Do(ctx, s.policy, func(ctx context.Context) error {
+		return s.update(ctx, metric)
+	})
 }
 
 func (s *SQLStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
-	updateList := func() error { return s.updateList(ctx, list) }
-	if err := s.retry(updateList); err != nil {
-		return err
-	}
-	return nil
+	return retry.Do(ctx, s.policy, func(ctx context.Context) error {
+		return s.updateList(ctx, list)
+	})
+}
+
+func (s *SQLStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	return retry.Do(ctx, s.policy, func(ctx context.Context) error {
+		return s.updateChanged(ctx, list)
+	})
 }
 
 func (s *SQLStorage) Ping() error {
-	ping := func() error { return s.ping() }
-	if err := s.retry(ping); err != nil {
-		return err
-	}
-	return nil
+	return retry.Do(context.Background(), s.policy, s.ping)
 }
 
-func (s *SQLStorage) SetRetryCount(attempts int) {
-	s.retrier.attempts = attempts
+func (s *SQLStorage) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return s.db.Close()
 }
 
-func (s *SQLStorage) SetRetryStartWaitTime(sleep time.Duration) {
-	s.retrier.time = sleep
+// SetLogger sets logger for errors of background history maintenance.
+func (s *SQLStorage) SetLogger(logger *zap.SugaredLogger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = logger
 }
 
-func (s *SQLStorage) SetRetryIncreaseWaitTime(delta time.Duration) {
-	s.retrier.delta = delta
+func (s *SQLStorage) logger() *zap.SugaredLogger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log
 }
 
-func (s *SQLStorage) retry(f func() error) error {
-	var err error
-	for i := 0; i <= s.retrier.attempts; i++ {
-		if i > 0 {
-			time.Sleep(s.retrier.time)
-			s.retrier.time += s.retrier.delta
-		}
-		err = f()
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) && !s.retrier.errToRetry[pgErr.Code] {
-				break
-			}
-		}
-		if err == nil {
-			return nil
-		}
-	}
-	return fmt.Errorf("after %d attempts, last error: %s", s.retrier.attempts, err)
+func (s *SQLStorage) SetRetryCount(attempts int) {
+	s.policy.Attempts = attempts
 }
 
-func (s *SQLStorage) retryWithMetric(f func() (models.MetricsWithValue, error)) (models.MetricsWithValue, error) {
-	var (
-		err    error
-		metric models.MetricsWithValue
-	)
-	for i := 0; i <= s.retrier.attempts; i++ {
-		if i > 0 {
-			time.Sleep(s.retrier.time)
-			s.retrier.time += s.retrier.delta
-		}
-		metric, err = f()
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) && !s.retrier.errToRetry[pgErr.Code] {
-				break
-			}
-		}
-		if err == nil {
-			return metric, nil
-		}
-	}
-	return metric, fmt.Errorf("after %d attempts, last error: %s", s.retrier.attempts, err)
+func (s *SQLStorage) SetRetryStartWaitTime(sleep time.Duration) {
+	s.policy.Wait = sleep
 }
 
-func (s *SQLStorage) retryWithMetrics(f func() ([]models.MetricsWithValue, error)) ([]models.MetricsWithValue, error) {
-	var err error
-	for i := 0; i <= s.retrier.attempts; i++ {
-		if i > 0 {
-			time.Sleep(s.retrier.time)
-			s.retrier.time += s.retrier.delta
-		}
-		list, err := f()
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) && !s.retrier.errToRetry[pgErr.Code] {
-				break
-			}
-		}
-		if err == nil {
-			return list, nil
-		}
-	}
-	return nil, fmt.Errorf("after %d attempts, last error: %s", s.retrier.attempts, err)
+func (s *SQLStorage) SetRetryIncreaseWaitTime(delta time.Duration) {
+	s.policy.Increase = delta
 }
 
 func (s *SQLStorage) get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
@@ -190,8 +181,12 @@ func (s *SQLStorage) get(ctx context.Context, metric models.MetricsWithValue) (m
 		var value int64
 		row := s.db.QueryRowContext(ctx, "SELECT metric_value FROM counter WHERE metric_id = $1", metric.ID)
 
-		if err := row.Scan(&value); err != nil {
-			return metric, errors.New("unknown metric name")
+		err := row.Scan(&value)
+		if errors.Is(err, sql.ErrNoRows) {
+			return metric, ErrUnknownMetric
+		}
+		if err != nil {
+			return metric, err
 		}
 		metric.Delta = value
 
@@ -199,8 +194,12 @@ func (s *SQLStorage) get(ctx context.Context, metric models.MetricsWithValue) (m
 		var value float64
 		row := s.db.QueryRowContext(ctx, "SELECT metric_value FROM gauge WHERE metric_id = $1", metric.ID)
 
-		if err := row.Scan(&value); err != nil {
-			return metric, errors.New("unknown metric name")
+		err := row.Scan(&value)
+		if errors.Is(err, sql.ErrNoRows) {
+			return metric, ErrUnknownMetric
+		}
+		if err != nil {
+			return metric, err
 		}
 		metric.Value = value
 	}
@@ -257,66 +256,115 @@ func (s *SQLStorage) getList(ctx context.Context) ([]models.MetricsWithValue, er
 }
 
 func (s *SQLStorage) update(ctx context.Context, metric models.MetricsWithValue) error {
-	switch metric.MType {
-	case "counter":
-		_, err := s.db.ExecContext(ctx,
-			`INSERT INTO counter (metric_id, metric_value) 
-			VALUES ($1, $2) 
-			ON CONFLICT (metric_id) DO UPDATE 
-			SET metric_value = EXCLUDED.metric_value + counter.metric_value;`, metric.ID, metric.Delta)
+	return s.upsert(ctx, aggregate([]models.MetricsWithValue{metric}, true), addCounters)
+}
+
+func (s *SQLStorage) updateList(ctx context.Context, list []models.MetricsWithValue) error {
+	return s.upsert(ctx, aggregate(list, true), addCounters)
+}
+
+func (s *SQLStorage) updateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	return s.upsert(ctx, aggregate(list, false), setCounters)
+}
+
+// upsert writes whole batch with one statement per type, upsertCounters
+// tells whether counters are added to stored values or replace them.
+func (s *SQLStorage) upsert(ctx context.Context, b batch, upsertCounters string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsertGauges := upsertGauges
+	if s.recordHistory {
+		upsertCounters = withHistory(upsertCounters, "counter")
+		upsertGauges = withHistory(upsertGauges, "gauge")
+	}
+
+	if len(b.counterIDs) > 0 {
+		_, err := tx.ExecContext(ctx, upsertCounters, b.counterIDs, b.counterValues)
 		if err != nil {
 			return err
 		}
+	}
 
-	case "gauge":
-		_, err := s.db.ExecContext(ctx,
-			`INSERT INTO gauge (metric_id, metric_value) 
-			VALUES ($1, $2) 
-			ON CONFLICT (metric_id) DO UPDATE 
-			SET metric_value = EXCLUDED.metric_value;`, metric.ID, metric.Value)
+	if len(b.gaugeIDs) > 0 {
+		_, err := tx.ExecContext(ctx, upsertGauges, b.gaugeIDs, b.gaugeValues)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (s *SQLStorage) updateList(ctx context.Context, list []models.MetricsWithValue) error {
-	tx, err := s.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+const (
+	addCounters = `INSERT INTO counter (metric_id, metric_value)
+		SELECT * FROM unnest($1::varchar[], $2::bigint[])
+		ON CONFLICT (metric_id) DO UPDATE
+		SET metric_value = EXCLUDED.metric_value + counter.metric_value`
+	setCounters = `INSERT INTO counter (metric_id, metric_value)
+		SELECT * FROM unnest($1::varchar[], $2::bigint[])
+		ON CONFLICT (metric_id) DO UPDATE
+		SET metric_value = EXCLUDED.metric_value`
+	upsertGauges = `INSERT INTO gauge (metric_id, metric_value)
+		SELECT * FROM unnest($1::varchar[], $2::double precision[])
+		ON CONFLICT (metric_id) DO UPDATE
+		SET metric_value = EXCLUDED.metric_value`
+)
+
+type batch struct {
+	counterIDs    []string
+	counterValues []int64
+	gaugeIDs      []string
+	gaugeValues   []float64
+}
 
+// aggregate leaves one row per metric, since ON CONFLICT can't update the
+// same row twice in one statement. Duplicate counters are summed up when
+// addCounters is set, otherwise the last value wins as for gauges. Rows are
+// sorted by ID, so concurrent batches lock rows in the same order.
+func aggregate(list []models.MetricsWithValue, addCounters bool) batch {
+	counters := make(map[string]int64)
+	gauges := make(map[string]float64)
 	for _, metric := range list {
 		switch metric.MType {
 		case "counter":
-			_, err := tx.ExecContext(ctx,
-				`INSERT INTO counter (metric_id, metric_value) 
-				VALUES ($1, $2) 
-				ON CONFLICT (metric_id) DO UPDATE 
-				SET metric_value = EXCLUDED.metric_value + counter.metric_value;`, metric.ID, metric.Delta)
-			if err != nil {
-				return err
+			if addCounters {
+				counters[metric.ID] += metric.Delta
+			} else {
+				counters[metric.ID] = metric.Delta
 			}
-
 		case "gauge":
-			_, err := tx.ExecContext(ctx,
-				`INSERT INTO gauge (metric_id, metric_value) 
-				VALUES ($1, $2) 
-				ON CONFLICT (metric_id) DO UPDATE 
-				SET metric_value = EXCLUDED.metric_value;`, metric.ID, metric.Value)
-
-			if err != nil {
-				return err
-			}
+			gauges[metric.ID] = metric.Value
 		}
 	}
-	return tx.Commit()
+
+	var b batch
+	b.counterIDs = sortedKeys(counters)
+	b.counterValues = make([]int64, len(b.counterIDs))
+	for i, id := range b.counterIDs {
+		b.counterValues[i] = counters[id]
+	}
+	b.gaugeIDs = sortedKeys(gauges)
+	b.gaugeValues = make([]float64, len(b.gaugeIDs))
+	for i, id := range b.gaugeIDs {
+		b.gaugeValues[i] = gauges[id]
+	}
+	return b
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
-func (s *SQLStorage) ping() error {
-	if err := s.db.Ping(); err != nil {
+func (s *SQLStorage) ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
 		return err
 	}
 	return nil
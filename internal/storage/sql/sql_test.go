@@ -0,0 +1,393 @@
+package sql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/h3ll0kitt1/observability/internal/config"
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/storage/storagetest"
+)
+
+// newTestStorage connects to TEST_DATABASE_DSN, tests using the database
+// are skipped without it. Tables are emptied before the test.
+func newTestStorage(tb testing.TB) *SQLStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := NewStorage(&config.ServerConfig{Database: dsn, DBAutoMigrate: true})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { s.Close() })
+
+	if _, err := s.db.Exec("TRUNCATE counter, gauge"); err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestSQLStorage_conformance(t *testing.T) {
+	storagetest.Main(t, func(t *testing.T) storagetest.MainStorage {
+		return newTestStorage(t)
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	list := []models.MetricsWithValue{
+		{ID: "b", MType: "counter", Delta: 1},
+		{ID: "a", MType: "gauge", Value: 1.5},
+		{ID: "b", MType: "counter", Delta: 2},
+		{ID: "a", MType: "counter", Delta: 5},
+		{ID: "a", MType: "gauge", Value: 2.5},
+		{ID: "c", MType: "unknown"},
+	}
+
+	tests := []struct {
+		name        string
+		addCounters bool
+		want        batch
+	}{
+		{
+			name:        "counters added",
+			addCounters: true,
+			want: batch{
+				counterIDs:    []string{"a", "b"},
+				counterValues: []int64{5, 3},
+				gaugeIDs:      []string{"a"},
+				gaugeValues:   []float64{2.5},
+			},
+		},
+		{
+			name:        "last counter wins",
+			addCounters: false,
+			want: batch{
+				counterIDs:    []string{"a", "b"},
+				counterValues: []int64{5, 2},
+				gaugeIDs:      []string{"a"},
+				gaugeValues:   []float64{2.5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregate(list, tt.addCounters); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "connection refused",
+			err:  errors.New("dial tcp 127.0.0.1:5432: connect: connection refused"),
+			want: true,
+		},
+		{
+			name: "connection failure",
+			err:  &pgconn.PgError{Code: pgerrcode.ConnectionFailure},
+			want: true,
+		},
+		{
+			name: "unique violation",
+			err:  fmt.Errorf("insert: %w", &pgconn.PgError{Code: pgerrcode.UniqueViolation}),
+			want: false,
+		},
+		{
+			name: "unknown metric",
+			err:  ErrUnknownMetric,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retriable(tt.err); got != tt.want {
+				t.Errorf("retriable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_retry(t *testing.T) {
+	update := func(ctx context.Context, s *SQLStorage) error {
+		return s.Update(ctx, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1})
+	}
+	get := func(ctx context.Context, s *SQLStorage) error {
+		_, err := s.Get(ctx, models.MetricsWithValue{ID: "c", MType: "counter"})
+		return err
+	}
+	ping := func(ctx context.Context, s *SQLStorage) error {
+		return s.Ping()
+	}
+
+	tests := []struct {
+		name      string
+		call      func(ctx context.Context, s *SQLStorage) error
+		script    []fakeStep
+		wantErr   error
+		wantCode  string
+		wantCalls int
+	}{
+		{
+			name:      "connection exception is retried",
+			call:      update,
+			script:    []fakeStep{{err: &pgconn.PgError{Code: pgerrcode.ConnectionException}}},
+			wantCalls: 2,
+		},
+		{
+			name: "retries run out",
+			call: update,
+			script: []fakeStep{
+				{err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}},
+				{err: &pgconn.PgError{Code: pgerrcode.ConnectionDoesNotExist}},
+				{err: &pgconn.PgError{Code: pgerrcode.SQLClientUnableToEstablishSQLConnection}},
+			},
+			wantCode:  pgerrcode.SQLClientUnableToEstablishSQLConnection,
+			wantCalls: 3,
+		},
+		{
+			name:      "dropped connection is retried on a new one",
+			call:      update,
+			script:    []fakeStep{{drop: true}},
+			wantCalls: 2,
+		},
+		{
+			name:      "ping is retried",
+			call:      ping,
+			script:    []fakeStep{{drop: true}, {err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}}},
+			wantCalls: 3,
+		},
+		{
+			name:      "unique violation is not retried",
+			call:      update,
+			script:    []fakeStep{{err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}}},
+			wantCode:  pgerrcode.UniqueViolation,
+			wantCalls: 1,
+		},
+		{
+			name:      "undefined table is not retried",
+			call:      get,
+			script:    []fakeStep{{err: &pgconn.PgError{Code: pgerrcode.UndefinedTable}}},
+			wantCode:  pgerrcode.UndefinedTable,
+			wantCalls: 1,
+		},
+		{
+			name:      "unknown metric is not retried",
+			call:      get,
+			wantErr:   ErrUnknownMetric,
+			wantCalls: 1,
+		},
+		{
+			name: "value is read after retry",
+			call: func(ctx context.Context, s *SQLStorage) error {
+				metric, err := s.Get(ctx, models.MetricsWithValue{ID: "c", MType: "counter"})
+				if err == nil && metric.Delta != 5 {
+					err = fmt.Errorf("Get() = %d, want 5", metric.Delta)
+				}
+				return err
+			},
+			script:    []fakeStep{{drop: true}, {rows: [][]driver.Value{{int64(5)}}}},
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake := newFakeStorage(t, tt.script...)
+			s.SetRetryCount(2)
+			s.SetRetryStartWaitTime(time.Millisecond)
+
+			err := tt.call(context.Background(), s)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantCode != "" {
+				var pgErr *pgconn.PgError
+				if !errors.As(err, &pgErr) || pgErr.Code != tt.wantCode {
+					t.Errorf("error = %v, want postgres error %s", err, tt.wantCode)
+				}
+			}
+			if tt.wantErr == nil && tt.wantCode == "" && err != nil {
+				t.Errorf("error = %v, want nil", err)
+			}
+
+			if calls := fake.Calls(); len(calls) != tt.wantCalls {
+				t.Errorf("%d statements run, want %d", len(calls), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSQLStorage_retryNewConnection(t *testing.T) {
+	s, fake := newFakeStorage(t, fakeStep{drop: true}, fakeStep{drop: true})
+	s.SetRetryCount(2)
+
+	if err := s.UpdateList(context.Background(), []models.MetricsWithValue{{ID: "g", MType: "gauge", Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if opened := fake.Opened(); opened != 3 {
+		t.Errorf("%d connections opened, want a new one for each attempt", opened)
+	}
+	calls := fake.Calls()
+	for _, call := range calls[1:] {
+		if call.query != calls[0].query {
+			t.Errorf("retried statement %q, want %q", call.query, calls[0].query)
+		}
+	}
+}
+
+func TestSQLStorage_retryBackoff(t *testing.T) {
+	failure := fakeStep{err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}}
+	s, fake := newFakeStorage(t, failure, failure, failure)
+	s.SetRetryCount(3)
+	s.SetRetryStartWaitTime(20 * time.Millisecond)
+	s.SetRetryIncreaseWaitTime(10 * time.Millisecond)
+
+	if err := s.Update(context.Background(), models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 4 {
+		t.Fatalf("%d statements run, want 4", len(calls))
+	}
+	// Waits grow by increase, jitter shortens them by 10% at most.
+	for i, want := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond, 40 * time.Millisecond} {
+		if wait := calls[i+1].at.Sub(calls[i].at); wait < want*9/10 {
+			t.Errorf("wait before retry %d = %v, want at least %v", i+1, wait, want*9/10)
+		}
+	}
+}
+
+func TestSQLStorage_retryDeadline(t *testing.T) {
+	s, fake := newFakeStorage(t, fakeStep{delay: time.Minute})
+	s.SetRetryCount(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.GetList(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetList() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetList() returned after %v, want right after deadline", elapsed)
+	}
+	if calls := fake.Calls(); len(calls) != 1 {
+		t.Errorf("%d statements run, want no retries after deadline", len(calls))
+	}
+}
+
+func TestSQLStorage_UpdateList(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	err := s.UpdateList(ctx, []models.MetricsWithValue{
+		{ID: "testCounter", MType: "counter", Delta: 1},
+		{ID: "testCounter", MType: "counter", Delta: 2},
+		{ID: "testGauge", MType: "gauge", Value: 1.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.UpdateList(ctx, []models.MetricsWithValue{
+		{ID: "testCounter", MType: "counter", Delta: 4},
+		{ID: "testGauge", MType: "gauge", Value: 2.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.MetricsWithValue{
+		{ID: "testCounter", MType: "counter", Delta: 7},
+		{ID: "testGauge", MType: "gauge", Value: 2.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() = %v, want %v", got, want)
+	}
+
+	if err := s.UpdateChanged(ctx, []models.MetricsWithValue{{ID: "testCounter", MType: "counter", Delta: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	metric, err := s.Get(ctx, models.MetricsWithValue{ID: "testCounter", MType: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metric.Delta != 3 {
+		t.Errorf("Get() after UpdateChanged = %d, want 3", metric.Delta)
+	}
+}
+
+func TestSQLStorage_UpdateListCanceled(t *testing.T) {
+	s := newTestStorage(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.updateList(ctx, []models.MetricsWithValue{{ID: "testCounter", MType: "counter", Delta: 1}})
+	if err == nil {
+		t.Fatal("updateList() with canceled context succeeded")
+	}
+}
+
+func benchmarkList(n int) []models.MetricsWithValue {
+	list := make([]models.MetricsWithValue, 0, n)
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			list = append(list, models.MetricsWithValue{ID: fmt.Sprintf("counter%d", i), MType: "counter", Delta: int64(i)})
+		} else {
+			list = append(list, models.MetricsWithValue{ID: fmt.Sprintf("gauge%d", i), MType: "gauge", Value: float64(i)})
+		}
+	}
+	return list
+}
+
+func BenchmarkAggregate(b *testing.B) {
+	list := benchmarkList(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aggregate(list, true)
+	}
+}
+
+func BenchmarkSQLStorage_UpdateList(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			s := newTestStorage(b)
+			list := benchmarkList(n)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := s.UpdateList(ctx, list); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
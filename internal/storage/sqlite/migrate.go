@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+
+	"github.com/h3ll0kitt1/observability/internal/schema"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// sqliteDialect has no lock of its own, so Lock and Unlock do nothing.
+// Instead the whole Up or Down runs in one transaction, which open makes
+// begin with BEGIN IMMEDIATE, taking the database write lock before
+// applied versions are read.
+type sqliteDialect struct{}
+
+func (sqliteDialect) Lock(ctx context.Context, conn *sql.Conn) error { return nil }
+
+func (sqliteDialect) Unlock(ctx context.Context, conn *sql.Conn) error { return nil }
+
+func (sqliteDialect) TableExists(ctx context.Context, conn *sql.Conn, name string) (bool, error) {
+	var count int
+	err := conn.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count > 0, err
+}
+
+func (sqliteDialect) Placeholder(n int) string { return "?" }
+
+func (sqliteDialect) SingleTx() bool { return true }
+
+func OpenMigrator(path string) (*schema.Migrator, error) {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	db, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	return schema.Open(db, sqliteDialect{}, sub)
+}
+
+// migrate applies pending migrations when autoMigrate is set, otherwise a
+// pending one fails with schema.ErrSchemaOutdated.
+func migrate(ctx context.Context, db *sql.DB, autoMigrate bool) error {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	return schema.Migrate(ctx, db, sqliteDialect{}, sub, autoMigrate)
+}
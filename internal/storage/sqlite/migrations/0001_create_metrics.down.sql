@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS gauge;
+
+DROP TABLE IF EXISTS counter;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS counter(
+	metric_id text primary key,
+	metric_value integer not null);
+
+CREATE TABLE IF NOT EXISTS gauge(
+	metric_id text primary key,
+	metric_value real not null);
@@ -0,0 +1,214 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/retry"
+	sqlstorage "github.com/h3ll0kitt1/observability/internal/storage/sql"
+)
+
+// SQLiteStorage keeps metrics in a SQLite file with the same tables and
+// upsert semantics as the postgres storage: counters are added up, gauges
+// replaced.
+type SQLiteStorage struct {
+	db     *sql.DB
+	policy retry.Policy
+}
+
+func NewStorage(path string, autoMigrate bool) (*SQLiteStorage, error) {
+	db, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := migrate(ctx, db, autoMigrate); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStorage{
+		db:     db,
+		policy: retry.Policy{Attempts: 1, Jitter: 0.1, Retriable: busy},
+	}, nil
+}
+
+// open uses WAL journal, so readers don't block the writer, and takes the
+// write lock when a transaction begins instead of failing to upgrade to it
+// in the middle. Busy timeout goes first, switching to WAL waits for other
+// connections too.
+func open(path string) (*sql.DB, error) {
+	query := url.Values{}
+	query.Add("_pragma", "busy_timeout(5000)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "synchronous(NORMAL)")
+	query.Set("_txlock", "immediate")
+
+	return sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+}
+
+// busy retries errors of database locked by another connection for longer
+// than busy timeout.
+func busy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+func (s *SQLiteStorage) Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	return retry.DoValue(ctx, s.policy, func(ctx context.Context) (models.MetricsWithValue, error) {
+		return s.get(ctx, metric)
+	})
+}
+
+func (s *SQLiteStorage) GetList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	return retry.DoValue(ctx, s.policy, s.getList)
+}
+
+func (s *SQLiteStorage) Update(ctx context.Context, metric models.MetricsWithValue) error {
+	return s.UpdateList(ctx, []models.MetricsWithValue{metric})
+}
+
+func (s *SQLiteStorage) UpdateList(ctx context.Context, list []models.MetricsWithValue) error {
+	return retry.Do(ctx, s.policy, func(ctx context.Context) error {
+		return s.upsert(ctx, list, addCounter)
+	})
+}
+
+func (s *SQLiteStorage) UpdateChanged(ctx context.Context, list []models.MetricsWithValue) error {
+	return retry.Do(ctx, s.policy, func(ctx context.Context) error {
+		return s.upsert(ctx, list, setCounter)
+	})
+}
+
+func (s *SQLiteStorage) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStorage) SetRetryCount(attempts int) {
+	s.policy.Attempts = attempts
+}
+
+func (s *SQLiteStorage) SetRetryStartWaitTime(sleep time.Duration) {
+	s.policy.Wait = sleep
+}
+
+func (s *SQLiteStorage) SetRetryIncreaseWaitTime(delta time.Duration) {
+	s.policy.Increase = delta
+}
+
+const (
+	addCounter = `INSERT INTO counter (metric_id, metric_value) VALUES (?, ?)
+		ON CONFLICT (metric_id) DO UPDATE
+		SET metric_value = counter.metric_value + excluded.metric_value`
+	setCounter = `INSERT INTO counter (metric_id, metric_value) VALUES (?, ?)
+		ON CONFLICT (metric_id) DO UPDATE
+		SET metric_value = excluded.metric_value`
+	upsertGauge = `INSERT INTO gauge (metric_id, metric_value) VALUES (?, ?)
+		ON CONFLICT (metric_id) DO UPDATE
+		SET metric_value = excluded.metric_value`
+)
+
+func (s *SQLiteStorage) get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error) {
+	var err error
+	switch metric.MType {
+	case "counter":
+		err = s.db.QueryRowContext(ctx, "SELECT metric_value FROM counter WHERE metric_id = ?", metric.ID).Scan(&metric.Delta)
+	case "gauge":
+		err = s.db.QueryRowContext(ctx, "SELECT metric_value FROM gauge WHERE metric_id = ?", metric.ID).Scan(&metric.Value)
+	default:
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return metric, sqlstorage.ErrUnknownMetric
+	}
+	return metric, err
+}
+
+func (s *SQLiteStorage) getList(ctx context.Context) ([]models.MetricsWithValue, error) {
+	list := make([]models.MetricsWithValue, 0)
+
+	counters, err := s.db.QueryContext(ctx, "SELECT metric_id, metric_value FROM counter")
+	if err != nil {
+		return nil, err
+	}
+	defer counters.Close()
+
+	for counters.Next() {
+		metric := models.MetricsWithValue{MType: "counter"}
+		if err := counters.Scan(&metric.ID, &metric.Delta); err != nil {
+			return nil, err
+		}
+		list = append(list, metric)
+	}
+	if err := counters.Err(); err != nil {
+		return nil, err
+	}
+
+	gauges, err := s.db.QueryContext(ctx, "SELECT metric_id, metric_value FROM gauge")
+	if err != nil {
+		return nil, err
+	}
+	defer gauges.Close()
+
+	for gauges.Next() {
+		metric := models.MetricsWithValue{MType: "gauge"}
+		if err := gauges.Scan(&metric.ID, &metric.Value); err != nil {
+			return nil, err
+		}
+		list = append(list, metric)
+	}
+	return list, gauges.Err()
+}
+
+// upsert applies list in one transaction, duplicate counters within it add
+// up as separate statements do.
+func (s *SQLiteStorage) upsert(ctx context.Context, list []models.MetricsWithValue, upsertCounter string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	counters, err := tx.PrepareContext(ctx, upsertCounter)
+	if err != nil {
+		return err
+	}
+	defer counters.Close()
+
+	gauges, err := tx.PrepareContext(ctx, upsertGauge)
+	if err != nil {
+		return err
+	}
+	defer gauges.Close()
+
+	for _, metric := range list {
+		switch metric.MType {
+		case "counter":
+			_, err = counters.ExecContext(ctx, metric.ID, metric.Delta)
+		case "gauge":
+			_, err = gauges.ExecContext(ctx, metric.ID, metric.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,157 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+	"github.com/h3ll0kitt1/observability/internal/schema"
+	sqlstorage "github.com/h3ll0kitt1/observability/internal/storage/sql"
+	"github.com/h3ll0kitt1/observability/internal/storage/storagetest"
+)
+
+func TestSQLiteStorage_Get(t *testing.T) {
+	s, err := NewStorage(filepath.Join(t.TempDir(), "metrics.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.Get(ctx, models.MetricsWithValue{ID: "unknown", MType: "counter"}); !errors.Is(err, sqlstorage.ErrUnknownMetric) {
+		t.Errorf("Get() of unknown metric error = %v, want %v", err, sqlstorage.ErrUnknownMetric)
+	}
+	if _, err := s.Get(ctx, models.MetricsWithValue{ID: "c", MType: "histogram"}); !errors.Is(err, sqlstorage.ErrUnknownMetric) {
+		t.Errorf("Get() of unknown type error = %v, want %v", err, sqlstorage.ErrUnknownMetric)
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+
+	if _, err := NewStorage(path, false); !errors.Is(err, schema.ErrSchemaOutdated) {
+		t.Fatalf("NewStorage() of fresh file without migrations error = %v, want %v", err, schema.ErrSchemaOutdated)
+	}
+
+	s, err := NewStorage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var mode string
+	if err := s.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q, want wal", mode)
+	}
+}
+
+// Writes of one storage have to be seen by another one opened on the same
+// file while the first is open, which needs WAL, and survive reopening.
+func TestSQLiteStorage_reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	ctx := context.Background()
+	want := models.MetricsWithValue{ID: "c", MType: "counter", Delta: 3}
+
+	writer, err := NewStorage(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Update(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewStorage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reader.Get(ctx, models.MetricsWithValue{ID: "c", MType: "counter"}); err != nil || got != want {
+		t.Errorf("Get() from second storage = %v, %v, want %v", got, err, want)
+	}
+	reader.Close()
+	writer.Close()
+
+	s, err := NewStorage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.Get(ctx, models.MetricsWithValue{ID: "c", MType: "counter"}); err != nil || got != want {
+		t.Errorf("Get() after reopening = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestMigrator_concurrentUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	ctx := context.Background()
+
+	const instances = 8
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		applied int
+		errs    []error
+	)
+	start := make(chan struct{})
+	for i := 0; i < instances; i++ {
+		m, err := OpenMigrator(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		if _, err := m.Status(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			n, err := m.Up(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			applied += n
+			errs = append(errs, err)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		t.Fatalf("concurrent Up() error = %v", err)
+	}
+	if want := len(mustStatus(t, path)); applied != want {
+		t.Errorf("instances applied %d migrations together, want each of %d once", applied, want)
+	}
+}
+
+func mustStatus(t *testing.T, path string) []schema.MigrationStatus {
+	m, err := OpenMigrator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestSQLiteStorage_conformance(t *testing.T) {
+	storagetest.Main(t, func(t *testing.T) storagetest.MainStorage {
+		s, err := NewStorage(filepath.Join(t.TempDir(), "metrics.db"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
@@ -0,0 +1,320 @@
+// Package storagetest checks that storage backends behave the way the
+// controller relies on. A backend test calls Main or Backup with a function
+// opening a new empty storage, every check gets a storage of its own.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/h3ll0kitt1/observability/internal/models"
+)
+
+// BackupStorage mirrors controller.BackupStorage, the controller package
+// can't be imported here as it imports the backends.
+type BackupStorage interface {
+	GetList(ctx context.Context) ([]models.MetricsWithValue, error)
+	UpdateList(ctx context.Context, list []models.MetricsWithValue) error
+	Close() error
+}
+
+// MainStorage mirrors controller.MainStorage.
+type MainStorage interface {
+	Get(ctx context.Context, metric models.MetricsWithValue) (models.MetricsWithValue, error)
+	Update(ctx context.Context, metric models.MetricsWithValue) error
+
+	BackupStorage
+}
+
+type check[S any] struct {
+	name string
+	run  func(t *testing.T, s S)
+}
+
+var backupChecks = []check[BackupStorage]{
+	{name: "empty", run: checkEmpty},
+	{name: "round trip", run: checkRoundTrip},
+	{name: "canceled context", run: checkBackupCanceled},
+}
+
+var mainChecks = []check[MainStorage]{
+	{name: "counter accumulation", run: checkCounterAccumulation},
+	{name: "gauge overwrite", run: checkGaugeOverwrite},
+	{name: "same name of both types", run: checkSameName},
+	{name: "unknown metric", run: checkUnknownMetric},
+	{name: "list completeness", run: checkListCompleteness},
+	{name: "concurrent updates", run: checkConcurrentUpdates},
+	{name: "canceled get and update", run: checkMainCanceled},
+}
+
+// Backup runs the checks every backup storage has to pass.
+func Backup(t *testing.T, open func(t *testing.T) BackupStorage) {
+	for _, c := range backupChecks {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, open(t))
+		})
+	}
+}
+
+// Main runs the backup checks and the checks of counter and gauge
+// semantics every main storage has to pass.
+func Main(t *testing.T, open func(t *testing.T) MainStorage) {
+	Backup(t, func(t *testing.T) BackupStorage { return open(t) })
+
+	for _, c := range mainChecks {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, open(t))
+		})
+	}
+}
+
+func sortList(list []models.MetricsWithValue) []models.MetricsWithValue {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MType != list[j].MType {
+			return list[i].MType < list[j].MType
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func getList(t *testing.T, s BackupStorage) []models.MetricsWithValue {
+	t.Helper()
+
+	list, err := s.GetList(context.Background())
+	if err != nil {
+		t.Fatalf("GetList() error = %v", err)
+	}
+	return sortList(list)
+}
+
+func get(t *testing.T, s MainStorage, mtype string, id string) models.MetricsWithValue {
+	t.Helper()
+
+	metric, err := s.Get(context.Background(), models.MetricsWithValue{ID: id, MType: mtype})
+	if err != nil {
+		t.Fatalf("Get(%s/%s) error = %v", mtype, id, err)
+	}
+	return metric
+}
+
+func update(t *testing.T, s MainStorage, metric models.MetricsWithValue) {
+	t.Helper()
+
+	if err := s.Update(context.Background(), metric); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+}
+
+func updateList(t *testing.T, s BackupStorage, list []models.MetricsWithValue) {
+	t.Helper()
+
+	if err := s.UpdateList(context.Background(), list); err != nil {
+		t.Fatalf("UpdateList() error = %v", err)
+	}
+}
+
+func checkEmpty(t *testing.T, s BackupStorage) {
+	if got := getList(t, s); len(got) != 0 {
+		t.Errorf("GetList() of new storage = %v, want empty", got)
+	}
+}
+
+func checkRoundTrip(t *testing.T, s BackupStorage) {
+	want := sortList([]models.MetricsWithValue{
+		{ID: "zero", MType: "counter"},
+		{ID: "large", MType: "counter", Delta: math.MaxInt64},
+		{ID: "negative", MType: "counter", Delta: -5},
+		{ID: "zero", MType: "gauge"},
+		{ID: "fraction", MType: "gauge", Value: 0.1},
+		{ID: "negative", MType: "gauge", Value: -1.25e10},
+		{ID: "tiny", MType: "gauge", Value: math.SmallestNonzeroFloat64},
+		{ID: "huge", MType: "gauge", Value: math.MaxFloat64},
+		{ID: "Name with spaces, ünïcode and \"quotes\"", MType: "gauge", Value: 1},
+	})
+
+	updateList(t, s, want)
+	if got := getList(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetList() = %v, want %v", got, want)
+	}
+}
+
+func checkBackupCanceled(t *testing.T, s BackupStorage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	list := []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: 1}}
+	if err := s.UpdateList(ctx, list); !errors.Is(err, context.Canceled) {
+		t.Errorf("UpdateList() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := s.GetList(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetList() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+	if got := getList(t, s); len(got) != 0 {
+		t.Errorf("GetList() after canceled UpdateList() = %v, want empty", got)
+	}
+}
+
+func checkCounterAccumulation(t *testing.T, s MainStorage) {
+	update(t, s, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1})
+	update(t, s, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 2})
+	updateList(t, s, []models.MetricsWithValue{
+		{ID: "c", MType: "counter", Delta: 3},
+		{ID: "c", MType: "counter", Delta: 4},
+	})
+	updateList(t, s, []models.MetricsWithValue{{ID: "c", MType: "counter", Delta: -5}})
+
+	if got := get(t, s, "counter", "c"); got.Delta != 5 {
+		t.Errorf("Get() = %d, want 5", got.Delta)
+	}
+}
+
+func checkGaugeOverwrite(t *testing.T, s MainStorage) {
+	update(t, s, models.MetricsWithValue{ID: "g", MType: "gauge", Value: 1.5})
+	update(t, s, models.MetricsWithValue{ID: "g", MType: "gauge", Value: 100})
+	updateList(t, s, []models.MetricsWithValue{
+		{ID: "g", MType: "gauge", Value: 2.5},
+		{ID: "g", MType: "gauge", Value: -3.25},
+	})
+
+	if got := get(t, s, "gauge", "g"); got.Value != -3.25 {
+		t.Errorf("Get() = %v, want -3.25", got.Value)
+	}
+}
+
+func checkSameName(t *testing.T, s MainStorage) {
+	updateList(t, s, []models.MetricsWithValue{
+		{ID: "x", MType: "counter", Delta: 7},
+		{ID: "x", MType: "gauge", Value: 0.5},
+	})
+
+	if got := get(t, s, "counter", "x"); got.Delta != 7 {
+		t.Errorf("Get() of counter = %d, want 7", got.Delta)
+	}
+	if got := get(t, s, "gauge", "x"); got.Value != 0.5 {
+		t.Errorf("Get() of gauge = %v, want 0.5", got.Value)
+	}
+}
+
+func checkUnknownMetric(t *testing.T, s MainStorage) {
+	update(t, s, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1})
+
+	ctx := context.Background()
+	for _, metric := range []models.MetricsWithValue{
+		{ID: "unknown", MType: "counter"},
+		{ID: "unknown", MType: "gauge"},
+		{ID: "c", MType: "gauge"},
+		{ID: "c", MType: "histogram"},
+	} {
+		if _, err := s.Get(ctx, metric); err == nil {
+			t.Errorf("Get(%s/%s) succeeded, want error", metric.MType, metric.ID)
+		}
+	}
+}
+
+func checkListCompleteness(t *testing.T, s MainStorage) {
+	want := make([]models.MetricsWithValue, 0, 500)
+	for i := 0; i < 250; i++ {
+		want = append(want,
+			models.MetricsWithValue{ID: fmt.Sprintf("counter%d", i), MType: "counter", Delta: int64(i)},
+			models.MetricsWithValue{ID: fmt.Sprintf("gauge%d", i), MType: "gauge", Value: float64(i) / 4},
+		)
+	}
+
+	updateList(t, s, want[:100])
+	for _, metric := range want[100:200] {
+		update(t, s, metric)
+	}
+	updateList(t, s, want[200:])
+
+	if got := getList(t, s); !reflect.DeepEqual(got, sortList(want)) {
+		t.Errorf("GetList() returned %d metrics, want all %d written", len(got), len(want))
+	}
+}
+
+// checkConcurrentUpdates is meant to be run with -race, besides counting
+// increments it lets the detector see writers and readers interleave.
+func checkConcurrentUpdates(t *testing.T, s MainStorage) {
+	const (
+		writers = 8
+		updates = 25
+	)
+
+	ctx := context.Background()
+	errs := make(chan error, 3*writers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(3)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				if err := s.Update(ctx, models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				err := s.UpdateList(ctx, []models.MetricsWithValue{
+					{ID: "c", MType: "counter", Delta: 1},
+					{ID: fmt.Sprintf("g%d", w), MType: "gauge", Value: float64(i)},
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				if _, err := s.GetList(ctx); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("concurrent call error = %v", err)
+	}
+
+	if got := get(t, s, "counter", "c"); got.Delta != 2*writers*updates {
+		t.Errorf("Get() = %d, want %d", got.Delta, 2*writers*updates)
+	}
+	for w := 0; w < writers; w++ {
+		if got := get(t, s, "gauge", fmt.Sprintf("g%d", w)); got.Value != updates-1 {
+			t.Errorf("Get(g%d) = %v, want %d", w, got.Value, updates-1)
+		}
+	}
+}
+
+func checkMainCanceled(t *testing.T, s MainStorage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	metric := models.MetricsWithValue{ID: "c", MType: "counter", Delta: 1}
+	if err := s.Update(ctx, metric); !errors.Is(err, context.Canceled) {
+		t.Errorf("Update() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := s.Get(ctx, metric); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := s.Get(context.Background(), metric); err == nil {
+		t.Error("Get() after canceled Update() succeeded, want unknown metric")
+	}
+}